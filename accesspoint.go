@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// parseAccessPointARN reports whether bucket is an S3 access point ARN rather
+// than a plain bucket name. The SDK routes requests and presigns against the
+// access point host when the ARN is passed as the Bucket, so nothing else
+// needs to change; this only rejects ARNs it would not understand.
+func parseAccessPointARN(bucket string) (*arn.ARN, error) {
+	if !arn.IsARN(bucket) {
+		return nil, nil
+	}
+
+	parsed, err := arn.Parse(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Service != "s3" {
+		return nil, fmt.Errorf("ARN service must be s3, got %q", parsed.Service)
+	}
+	if !strings.HasPrefix(parsed.Resource, "accesspoint/") && !strings.HasPrefix(parsed.Resource, "accesspoint:") {
+		return nil, fmt.Errorf("ARN must reference an access point, got resource %q", parsed.Resource)
+	}
+	if parsed.Region == "" || parsed.AccountID == "" {
+		return nil, fmt.Errorf("access point ARN must include a region and account ID")
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestParseAccessPointARN(t *testing.T) {
+	tests := []struct {
+		name       string
+		bucket     string
+		wantRegion string
+		wantErr    bool
+	}{
+		{"plain bucket", "my-bucket", "", false},
+		{"access point", "arn:aws:s3:eu-west-1:111122223333:accesspoint/uploads", "eu-west-1", false},
+		{"access point with colon", "arn:aws:s3:eu-west-1:111122223333:accesspoint:uploads", "eu-west-1", false},
+		{"other service", "arn:aws:sqs:eu-west-1:111122223333:queue", "", true},
+		{"not an access point", "arn:aws:s3:::my-bucket", "", true},
+		{"no region", "arn:aws:s3::111122223333:accesspoint/uploads", "", true},
+		{"no account", "arn:aws:s3:eu-west-1::accesspoint/uploads", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAccessPointARN(tt.bucket)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAccessPointARN(%q) error = %v, want error %v", tt.bucket, err, tt.wantErr)
+			}
+			var region string
+			if got != nil {
+				region = got.Region
+			}
+			if region != tt.wantRegion {
+				t.Errorf("parseAccessPointARN(%q) region = %q, want %q", tt.bucket, region, tt.wantRegion)
+			}
+		})
+	}
+}
+
+// An access point in another region than AWS_REGION is presigned against
+// its own host and region, as main configures the client.
+func TestPresignAccessPoint(t *testing.T) {
+	setGlobal(t, &bucket, "arn:aws:s3:eu-west-1:111122223333:accesspoint/uploads")
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("AK", "SK", ""),
+		UseARNRegion: true,
+	})
+	req, err := s3.NewPresignClient(client).PresignPutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(keyPrefix + "a.png"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "uploads-111122223333.s3-accesspoint.eu-west-1.amazonaws.com"; u.Host != want {
+		t.Errorf("host = %q, want %q", u.Host, want)
+	}
+	if credential := u.Query().Get("X-Amz-Credential"); !strings.Contains(credential, "/eu-west-1/s3/") {
+		t.Errorf("X-Amz-Credential = %q, want the eu-west-1 scope", credential)
+	}
+	if err := validatePresignedURL(req.URL, keyPrefix+"a.png"); err != nil {
+		t.Errorf("validatePresignedURL: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// defaultACL, set by DEFAULT_ACL, is the canned ACL given to objects whose
+// request doesn't name one. It is empty when objects get none.
+var defaultACL types.ObjectCannedACL
+
+// aclsDisabled is set at startup when the bucket enforces bucket-owner
+// object ownership, under which S3 rejects any write that sets an ACL.
+var aclsDisabled bool
+
+// errACLsDisabled is returned for requests naming an ACL the bucket can't
+// honor.
+var errACLsDisabled = errors.New("ACLs are disabled on this bucket (object ownership is BucketOwnerEnforced)")
+
+func parseCannedACL(v string) (types.ObjectCannedACL, error) {
+	for _, acl := range types.ObjectCannedACL("").Values() {
+		if string(acl) == v {
+			return acl, nil
+		}
+	}
+	return "", fmt.Errorf("acl must be a canned ACL such as private or public-read")
+}
+
+// objectACL returns the ACL to set on a write from the "acl" query
+// parameter, falling back to defaultACL. It is empty, leaving the ACL unset,
+// when ACLs are disabled and the request didn't ask for one.
+func objectACL(query url.Values) (types.ObjectCannedACL, error) {
+	v := query.Get("acl")
+	if v == "" {
+		if aclsDisabled {
+			return "", nil
+		}
+		return defaultACL, nil
+	}
+	acl, err := parseCannedACL(v)
+	if err != nil {
+		return "", err
+	}
+	if aclsDisabled {
+		return "", errACLsDisabled
+	}
+	// S3 refuses a canned ACL and explicit grants on the same write
+	if grants.set() {
+		return "", errors.New("acl can't be combined with the configured GRANT_* grants")
+	}
+	return acl, nil
+}
+
+// checkObjectOwnership logs the bucket's object ownership setting and sets
+// aclsDisabled when it is BucketOwnerEnforced. A bucket without ownership
+// controls predates them and accepts ACLs. If the setting can't be read the
+// ACLs are left to S3 to accept or reject.
+func checkObjectOwnership(ctx context.Context) {
+	resp, err := s3Client.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{
+		Bucket: aws.String(bucket),
+	})
+	if hasErrorCode(err, "OwnershipControlsNotFoundError") {
+		log.Printf("Bucket %s has no object ownership controls; ACLs are enabled", bucket)
+		return
+	}
+	if err != nil {
+		log.Printf("Warning: unable to check object ownership on bucket %s: %v", bucket, err)
+		return
+	}
+
+	var ownership types.ObjectOwnership
+	if resp.OwnershipControls != nil && len(resp.OwnershipControls.Rules) > 0 {
+		ownership = resp.OwnershipControls.Rules[0].ObjectOwnership
+	}
+	log.Printf("Object ownership on bucket %s is %s", bucket, ownership)
+	if ownership != types.ObjectOwnershipBucketOwnerEnforced {
+		return
+	}
+	aclsDisabled = true
+	if defaultACL != "" {
+		log.Printf("Warning: ignoring DEFAULT_ACL %s since ACLs are disabled on bucket %s", defaultACL, bucket)
+	}
+	if grants.set() {
+		log.Printf("Warning: ignoring GRANT_* grants since ACLs are disabled on bucket %s", bucket)
+		grants = objectGrants{}
+	}
+	// Published copies are owned by the bucket owner without being asked
+	if publishACL != "" || publishGrants.set() {
+		log.Printf("Warning: ignoring the ACL and grants of published copies since ACLs are disabled on bucket %s", bucket)
+		publishACL, publishGrants = "", objectGrants{}
+	}
+}
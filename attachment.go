@@ -0,0 +1,65 @@
+package main
+
+import (
+	"mime"
+	"path"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// attachmentExtensions, from ATTACHMENT_EXTENSIONS, are the extensions of
+// files a browser would run script from if it rendered them, such as SVG and
+// HTML. Downloads of these are always served as an octet-stream attachment,
+// whatever type is stored or requested, so a user-uploaded page can't
+// execute in the viewer's browser.
+var attachmentExtensions map[string]bool
+
+func newAttachmentExtensions(exts []string) map[string]bool {
+	if len(exts) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		set[normalizeExt(ext)] = true
+	}
+	return set
+}
+
+// forceAttachment makes S3 answer input with Content-Disposition: attachment
+// and Content-Type: application/octet-stream when filename has one of the
+// attachmentExtensions. It reports whether it did.
+func forceAttachment(input *s3.GetObjectInput, filename string) bool {
+	if !attachmentExtensions[normalizeExt(filepath.Ext(filename))] {
+		return false
+	}
+	input.ResponseContentDisposition = aws.String(attachmentDisposition(filename))
+	input.ResponseContentType = aws.String("application/octet-stream")
+	return true
+}
+
+func attachmentDisposition(filename string) string {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(filename)})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	return disposition
+}
+
+// scriptableTypes are the stored content types a browser runs script from
+// when it renders them, whatever the object's extension.
+var scriptableTypes = map[string]bool{
+	"text/html":             true,
+	"image/svg+xml":         true,
+	"application/xhtml+xml": true,
+}
+
+func scriptableType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Browsers sniff what they can't parse, so treat it as the worst case
+		return contentType != ""
+	}
+	return scriptableTypes[mediaType]
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// loadAWSConfig loads the SDK config, taking credentials from the first of
+// these that applies:
+//
+//   - AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, as static keys
+//   - AWS_PROFILE, from the shared config and credentials files
+//   - the SDK's default chain: environment, shared config, then instance or
+//     task roles
+//
+// It also returns a description of the source for logging. An AWS_PROFILE
+// missing from the shared files is an error rather than a fallback to the
+// default chain.
+func loadAWSConfig(ctx context.Context, conf *Config, optFns ...func(*config.LoadOptions) error) (aws.Config, string, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(conf.Region),
+		config.WithAPIOptions(userAgentOptions(conf.UserAgentProduct)),
+	}
+	var source string
+	switch {
+	case conf.AccessKeyID != "":
+		opts = append(opts, config.WithCredentialsProvider(
+			aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
+				conf.AccessKeyID,
+				conf.SecretAccessKey,
+				"",
+			)),
+		))
+		source = "static credentials from AWS_ACCESS_KEY_ID"
+	case conf.Profile != "":
+		opts = append(opts, config.WithSharedConfigProfile(conf.Profile))
+		source = fmt.Sprintf("shared config profile %q", conf.Profile)
+	default:
+		source = "the default credential chain"
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, append(opts, optFns...)...)
+	if err != nil {
+		return aws.Config{}, "", err
+	}
+	return awsCfg, source, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAWSConfig(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	err := os.WriteFile(credentialsFile, []byte("[uploads]\naws_access_key_id = PROFILEKEY\naws_secret_access_key = PROFILESECRET\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep the machine's own environment and files out of the default chain
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "ENVSECRET")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+
+	tests := []struct {
+		name    string
+		conf    Config
+		wantKey string
+		wantErr bool
+	}{
+		{"static keys", Config{AccessKeyID: "STATICKEY", SecretAccessKey: "STATICSECRET"}, "STATICKEY", false},
+		{"static keys win over a profile", Config{AccessKeyID: "STATICKEY", SecretAccessKey: "STATICSECRET", Profile: "uploads"}, "STATICKEY", false},
+		{"profile", Config{Profile: "uploads"}, "PROFILEKEY", false},
+		{"missing profile", Config{Profile: "nope"}, "", true},
+		{"default chain", Config{}, "ENVKEY", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.Region = "us-east-1"
+			awsCfg, source, err := loadAWSConfig(context.Background(), &tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadAWSConfig error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if source == "" {
+				t.Error("source is empty")
+			}
+			creds, err := awsCfg.Credentials.Retrieve(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.AccessKeyID != tt.wantKey {
+				t.Errorf("AccessKeyID = %q, want %q", creds.AccessKeyID, tt.wantKey)
+			}
+		})
+	}
+}
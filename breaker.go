@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/sony/gobreaker"
+)
+
+// breakerTimeout is how long the S3 circuit breaker stays open before it
+// probes S3 again, and so how long clients are told to wait.
+var breakerTimeout time.Duration
+
+// errServerError counts a 5xx from S3 as a failure without hiding the
+// response from the SDK, which still decodes its error.
+var errServerError = errors.New("S3 returned a server error")
+
+// breakerOpenError is returned in place of a request the breaker refused to
+// send. It marks itself non-retryable so the SDK fails straight away instead
+// of backing off against a breaker that is still open.
+type breakerOpenError struct {
+	err error
+}
+
+func (e *breakerOpenError) Error() string        { return "S3 circuit breaker: " + e.err.Error() }
+func (e *breakerOpenError) Unwrap() error        { return e.err }
+func (e *breakerOpenError) RetryableError() bool { return false }
+
+// breakerClient sends each S3 request attempt through a circuit breaker that
+// trips after failures consecutive transport errors or 5xx responses, fails
+// calls immediately for timeout, then lets probes requests through to test
+// for recovery. It wraps the HTTP client rather than the operations so that
+// presigning, which never reaches S3, keeps working while the breaker is open.
+type breakerClient struct {
+	next    aws.HTTPClient
+	breaker *gobreaker.CircuitBreaker
+}
+
+func newBreakerClient(next aws.HTTPClient, failures, probes int, timeout time.Duration) *breakerClient {
+	breakerState.Set(float64(gobreaker.StateClosed))
+	return &breakerClient{
+		next: next,
+		breaker: gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:        "s3",
+			MaxRequests: uint32(probes),
+			Timeout:     timeout,
+			ReadyToTrip: func(counts gobreaker.Counts) bool {
+				return counts.ConsecutiveFailures >= uint32(failures)
+			},
+			OnStateChange: func(_ string, from, to gobreaker.State) {
+				log.Printf("S3 circuit breaker %s -> %s", from, to)
+				breakerState.Set(float64(to))
+			},
+			// A caller going away says nothing about S3's health
+			IsSuccessful: func(err error) bool {
+				return err == nil || errors.Is(err, context.Canceled)
+			},
+		}),
+	}
+}
+
+func (c *breakerClient) Do(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	_, err := c.breaker.Execute(func() (any, error) {
+		var err error
+		resp, err = c.next.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, errServerError
+		}
+		return nil, nil
+	})
+	switch {
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		breakerRejected.Inc()
+		return nil, &breakerOpenError{err}
+	case errors.Is(err, errServerError):
+		return resp, nil
+	}
+	return resp, err
+}
+
+// respondBreakerOpen answers with 503 when err is the breaker refusing a
+// call, with a Retry-After of when it will next probe S3. It reports whether
+// it wrote a response.
+func respondBreakerOpen(w http.ResponseWriter, err error) bool {
+	var openErr *breakerOpenError
+	if !errors.As(err, &openErr) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(breakerTimeout.Seconds())))
+	http.Error(w, "S3 is unavailable, retry later", http.StatusServiceUnavailable)
+	return true
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// checkAccessLogging logs whether server access logging is enabled on the
+// bucket. When required it warns if logging is off, and in strict mode refuses
+// to start instead.
+func checkAccessLogging(ctx context.Context, required, strict bool) {
+	resp, err := s3Client.GetBucketLogging(ctx, &s3.GetBucketLoggingInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		if required && strict {
+			log.Fatalf("Unable to check access logging on bucket %s: %v", bucket, err)
+		}
+		log.Printf("Warning: unable to check access logging on bucket %s: %v", bucket, err)
+		return
+	}
+
+	if resp.LoggingEnabled != nil {
+		log.Printf("Access logging enabled on bucket %s, target %s/%s",
+			bucket, aws.ToString(resp.LoggingEnabled.TargetBucket), aws.ToString(resp.LoggingEnabled.TargetPrefix))
+		return
+	}
+
+	switch {
+	case required && strict:
+		log.Fatalf("Access logging is disabled on bucket %s but REQUIRE_ACCESS_LOGGING is set", bucket)
+	case required:
+		log.Printf("Warning: access logging is disabled on bucket %s but REQUIRE_ACCESS_LOGGING is set", bucket)
+	default:
+		log.Printf("Access logging disabled on bucket %s", bucket)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxInvalidationPaths bounds a single CreateInvalidation request.
+const maxInvalidationPaths = 1000
+
+// cdnInvalidator batches CloudFront invalidations for objects our endpoints
+// overwrite or delete. It is best effort: requests never wait on it and
+// failures are only logged.
+type cdnInvalidator struct {
+	client         *cloudfront.Client
+	distributionId string
+	window         time.Duration
+	paths          chan string
+}
+
+// invalidator is nil unless CLOUDFRONT_DISTRIBUTION_ID is set, in which case
+// invalidate is a no-op.
+var invalidator *cdnInvalidator
+
+func newCDNInvalidator(client *cloudfront.Client, distributionId string, window time.Duration) *cdnInvalidator {
+	inv := &cdnInvalidator{
+		client:         client,
+		distributionId: distributionId,
+		window:         window,
+		paths:          make(chan string, maxInvalidationPaths),
+	}
+	go inv.run()
+	return inv
+}
+
+// invalidate queues key for invalidation in the next batch.
+func (inv *cdnInvalidator) invalidate(key string) {
+	if inv == nil {
+		return
+	}
+	select {
+	case inv.paths <- "/" + key:
+	default:
+		log.Printf("CDN invalidation queue full, dropping %s", key)
+	}
+}
+
+// overwrites reports whether a write to key would replace an object, the only
+// case worth invalidating: a key that never held an object has nothing
+// cached. It asks S3 only while invalidation is enabled, and counts a key it
+// can't check as existing.
+func (inv *cdnInvalidator) overwrites(ctx context.Context, key string) bool {
+	if inv == nil {
+		return false
+	}
+	_, err := headObject(ctx, key)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Unable to check whether %s exists, invalidating it: %v", key, err)
+	}
+	return true
+}
+
+// run collects paths for one window after the first arrives, then sends them
+// as a single invalidation.
+func (inv *cdnInvalidator) run() {
+	for path := range inv.paths {
+		batch := map[string]struct{}{path: {}}
+		timeout := time.After(inv.window)
+	collect:
+		for len(batch) < maxInvalidationPaths {
+			select {
+			case p := <-inv.paths:
+				batch[p] = struct{}{}
+			case <-timeout:
+				break collect
+			}
+		}
+		inv.flush(batch)
+	}
+}
+
+func (inv *cdnInvalidator) flush(batch map[string]struct{}) {
+	items := make([]string, 0, len(batch))
+	for path := range batch {
+		items = append(items, path)
+	}
+
+	resp, err := inv.client.CreateInvalidation(context.TODO(), &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(inv.distributionId),
+		InvalidationBatch: &cftypes.InvalidationBatch{
+			CallerReference: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10)),
+			Paths: &cftypes.Paths{
+				Quantity: aws.Int32(int32(len(items))),
+				Items:    items,
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating CloudFront invalidation for %d paths: %v", len(items), err)
+		return
+	}
+	log.Printf("Created CloudFront invalidation %s for %d paths", aws.ToString(resp.Invalidation.Id), len(items))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// queuedInvalidations installs an invalidator that only queues, and returns
+// the paths queued so far.
+func queuedInvalidations(t *testing.T) func() []string {
+	inv := &cdnInvalidator{paths: make(chan string, maxInvalidationPaths)}
+	setGlobal(t, &invalidator, inv)
+	return func() []string {
+		var paths []string
+		for {
+			select {
+			case p := <-inv.paths:
+				paths = append(paths, p)
+			default:
+				return paths
+			}
+		}
+	}
+}
+
+// existingS3 answers HeadObject with 404 for keys not in existing, and
+// accepts any PutObject or CompleteMultipartUpload.
+func existingS3(t *testing.T, existing ...string) {
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		switch r.Method {
+		case http.MethodHead:
+			for _, key := range existing {
+				if r.URL.Path == "/b/"+key {
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case http.MethodPost:
+			w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"c-1"</ETag></CompleteMultipartUploadResult>`))
+		case http.MethodPut:
+			w.Header().Set("ETag", `"e"`)
+		}
+	})
+	setGlobal(t, &uploader, newUploader(s3Client, 5<<20, 2))
+	setGlobal(t, &multipartThreshold, 64<<20)
+}
+
+func TestInvalidateOnlyOverwrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		want     []string
+	}{
+		{"new key", nil, nil},
+		{"overwrite", []string{keyPrefix + "a.txt"}, []string{"/" + keyPrefix + "a.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run("upload "+tt.name, func(t *testing.T) {
+			existingS3(t, tt.existing...)
+			queued := queuedInvalidations(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/upload?filename=a.txt", strings.NewReader("hello"))
+			req.Header.Set("Content-Type", "text/plain")
+			rec := httptest.NewRecorder()
+			routes().ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			if got := queued(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("invalidated %v, want %v", got, tt.want)
+			}
+		})
+		t.Run("complete "+tt.name, func(t *testing.T) {
+			existingS3(t, tt.existing...)
+			queued := queuedInvalidations(t)
+
+			rec := serve(t, http.MethodPost, "/multipart/complete", `{"key":"`+keyPrefix+`a.txt","uploadId":"U1","parts":[{"eTag":"e1","partNumber":1}]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			if got := queued(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("invalidated %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// With If-None-Match the upload can only create the key, so there is
+// nothing to check or invalidate.
+func TestInvalidateNotOnCreateOnlyUpload(t *testing.T) {
+	existingS3(t, keyPrefix+"a.txt")
+	setGlobal(t, &uploadCollision, collisionReject)
+	queued := queuedInvalidations(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/upload?filename=a.txt", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	if got := queued(); len(got) != 0 {
+		t.Errorf("invalidated %v, want nothing", got)
+	}
+}
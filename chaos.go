@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// chaosLatency and chaosErrorRate, from CHAOS_LATENCY and CHAOS_ERROR_RATE,
+// make requests misbehave on purpose so client retries and backoff can be
+// tested against a real deployment: each request is held for a random delay
+// of up to chaosLatency, then fails with a 503 with probability
+// chaosErrorRate. Both are off by default and must never be set in
+// production. The probes, /metrics and /version are spared so the chaos
+// doesn't get the instance restarted or hide that it is on.
+var (
+	chaosLatency   time.Duration
+	chaosErrorRate float64
+)
+
+var chaosExempt = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+	"/version": true,
+}
+
+func chaosEnabled() bool {
+	return chaosLatency > 0 || chaosErrorRate > 0
+}
+
+func logChaos() {
+	if chaosEnabled() {
+		log.Printf("WARNING: chaos mode is on, adding up to %s of latency and failing %.1f%% of requests", chaosLatency, chaosErrorRate*100)
+	}
+}
+
+// injectChaos applies CHAOS_LATENCY and CHAOS_ERROR_RATE ahead of next.
+func injectChaos(next http.Handler) http.Handler {
+	if !chaosEnabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if chaosExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if chaosLatency > 0 {
+			select {
+			case <-time.After(rand.N(chaosLatency)):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if rand.Float64() < chaosErrorRate {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Injected failure (chaos mode)", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleVersion reports the build version and whether chaos mode is on, so
+// a misbehaving instance can be told apart from one being tested.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"version": version,
+		"chaos": map[string]any{
+			"enabled":   chaosEnabled(),
+			"latency":   chaosLatency.String(),
+			"errorRate": chaosErrorRate,
+		},
+	})
+}
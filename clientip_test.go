@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{"bare IPv4", []string{"10.0.0.1"}, []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}, false},
+		{"bare IPv6", []string{"::1"}, []netip.Prefix{netip.MustParsePrefix("::1/128")}, false},
+		{"CIDR is masked", []string{" 10.1.2.3/8 "}, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, false},
+		{"invalid IP", []string{"10.0.0.256"}, nil, true},
+		{"invalid CIDR", []string{"10.0.0.0/33"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTrustedProxies(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTrustedProxies(%q) error = %v, want error %v", tt.entries, err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseTrustedProxies(%q) = %v, want %v", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setGlobal(t, &trustedProxies, proxies)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer's header is ignored", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:5000", []string{"198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"prepended entry is ignored", "10.0.0.2:5000", []string{"192.0.2.99, 198.51.100.1"}, "198.51.100.1"},
+		{"repeated headers are joined", "10.0.0.2:5000", []string{"198.51.100.1", "10.0.0.3"}, "198.51.100.1"},
+		{"garbage stops the walk", "10.0.0.2:5000", []string{"198.51.100.1, junk"}, "10.0.0.2"},
+		{"IPv6 proxy", "[::1]:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"IPv4-mapped proxy", "[::ffff:10.0.0.2]:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"all hops trusted", "10.0.0.2:5000", []string{"10.0.0.3"}, "10.0.0.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
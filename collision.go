@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Strategies for an /upload whose key is already taken, chosen by
+// UPLOAD_COLLISION.
+const (
+	collisionOverwrite = "overwrite"
+	collisionReject    = "reject"
+	collisionVersion   = "version"
+)
+
+var uploadCollision = collisionOverwrite
+
+// maxKeyVersions bounds how many suffixed keys the version strategy tries.
+const maxKeyVersions = 100
+
+// errNoFreeKey means every versioned key the version strategy tried exists.
+var errNoFreeKey = errors.New("no free versioned key")
+
+// versionedKey returns key with "-n" inserted before its extension, so
+// "uploads/cat.jpg" becomes "uploads/cat-2.jpg".
+func versionedKey(key string, n int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(key, ext), n, ext)
+}
+
+// freeKey returns key, or the first of its versioned forms, that no object
+// holds yet. Another upload can still take the key between the check and the
+// write, so the write should also carry If-None-Match.
+func freeKey(ctx context.Context, key string) (string, error) {
+	candidate := key
+	for n := 1; n <= maxKeyVersions; n++ {
+		_, err := headObject(ctx, candidate)
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = versionedKey(key, n)
+	}
+	return "", errNoFreeKey
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// partMismatch is a part of a complete request that
+// CompleteMultipartUpload would reject.
+type partMismatch struct {
+	PartNumber int32 `json:"partNumber"`
+	// Problem is "missing", "eTagMismatch" or "tooSmall"
+	Problem      string `json:"problem"`
+	DeclaredETag string `json:"declaredETag,omitempty"`
+	UploadedETag string `json:"uploadedETag,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+}
+
+// verifyParts compares the parts a client wants completed against what
+// ListParts says S3 holds, so a complete that would fail gets a list of what
+// is wrong rather than S3's first error. S3 also rejects parts other than the
+// last under minPartSize, so those are reported too. The parts cache isn't
+// used: this must see the upload as it is now.
+func verifyParts(ctx context.Context, key, uploadId string, parts []types.CompletedPart) ([]partMismatch, error) {
+	uploaded := make(map[int32]types.Part)
+	paginator := s3.NewListPartsPaginator(s3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range page.Parts {
+			uploaded[aws.ToInt32(part.PartNumber)] = part
+		}
+	}
+
+	var mismatches []partMismatch
+	for i, part := range parts {
+		partNumber := aws.ToInt32(part.PartNumber)
+		actual, ok := uploaded[partNumber]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, partMismatch{
+				PartNumber: partNumber,
+				Problem:    "missing",
+			})
+		case unquoteETag(aws.ToString(actual.ETag)) != unquoteETag(aws.ToString(part.ETag)):
+			mismatches = append(mismatches, partMismatch{
+				PartNumber:   partNumber,
+				Problem:      "eTagMismatch",
+				DeclaredETag: aws.ToString(part.ETag),
+				UploadedETag: aws.ToString(actual.ETag),
+			})
+		case i < len(parts)-1 && aws.ToInt64(actual.Size) < minPartSize:
+			mismatches = append(mismatches, partMismatch{
+				PartNumber: partNumber,
+				Problem:    "tooSmall",
+				Size:       aws.ToInt64(actual.Size),
+			})
+		}
+	}
+	return mismatches, nil
+}
+
+// unquoteETag strips the quotes S3 puts around ETags, which clients may or
+// may not keep.
+func unquoteETag(eTag string) string {
+	return strings.Trim(eTag, `"`)
+}
+
+// respondPartMismatches answers 409 with the parts verifyParts found wrong.
+func respondPartMismatches(w http.ResponseWriter, mismatches []partMismatch) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": "parts do not match the upload",
+		"parts": mismatches,
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/jpeg"
+)
+
+// compressQuality, compressMinSize and compressMaxSize, from
+// COMPRESS_QUALITY, COMPRESS_MIN_SIZE and COMPRESS_MAX_SIZE, control the
+// compress option of /upload. Bodies outside the sizes are stored as sent:
+// small ones have little to gain and large ones would be held in memory whole.
+var (
+	compressQuality = 85
+	compressMinSize int64
+	compressMaxSize int64
+)
+
+// maxCompressPixels stops a small file declaring huge dimensions from
+// decoding into gigabytes of pixels.
+const maxCompressPixels = 100_000_000
+
+// recompressJPEG re-encodes a JPEG at compressQuality, carrying over its APP1
+// Exif and XMP and APP2 ICC profile segments, which Go's encoder doesn't
+// write, so the orientation and colours survive. The pixels are never
+// rotated, so the Exif orientation still applies to them. It returns nil when
+// the result isn't smaller.
+func recompressJPEG(data []byte) ([]byte, error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxCompressPixels {
+		return nil, fmt.Errorf("%dx%d image is too large to recompress", cfg.Width, cfg.Height)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: compressQuality}); err != nil {
+		return nil, err
+	}
+	segments := jpegMetadata(data)
+	if encoded.Len()+len(segments) >= len(data) {
+		return nil, nil
+	}
+	out := make([]byte, 0, encoded.Len()+len(segments))
+	out = append(out, encoded.Bytes()[:2]...) // SOI
+	out = append(out, segments...)
+	return append(out, encoded.Bytes()[2:]...), nil
+}
+
+// jpegMetadata returns the APP1 and APP2 ICC profile segments found between
+// a JPEG's SOI and its first scan.
+func jpegMetadata(data []byte) []byte {
+	var segments []byte
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xff {
+			// Fill byte before the marker
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			break
+		}
+		payload := data[i+4 : end]
+		if marker == 0xe1 || (marker == 0xe2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))) {
+			segments = append(segments, data[i:end]...)
+		}
+		i = end
+	}
+	return segments
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+)
+
+// limitConcurrency answers 503 once limit requests are already in flight,
+// shedding load instead of queueing it. A limit of zero disables it.
+func limitConcurrency(limit int, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			overloadRejected.Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Server is busy, retry later", http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		inFlight int
+		want     int
+	}{
+		{"disabled", 0, 5, http.StatusOK},
+		{"under the limit", 3, 2, http.StatusOK},
+		{"at the limit", 3, 3, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			h := limitConcurrency(tt.limit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					started <- struct{}{}
+					<-release
+				}
+			}))
+
+			var wg sync.WaitGroup
+			for range tt.inFlight {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+				}()
+				<-started
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			close(release)
+			wg.Wait()
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Error("503 without Retry-After")
+			}
+
+			// Finished requests give their slots back
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("after the others finished: status = %d, want 200", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting read at startup. Values start from
+// defaultConfig, are replaced by the optional YAML or JSON config file, and
+// environment variables named in the env tags override both.
+//
+// In the environment, lists of strings are comma separated, while other lists
+// are JSON arrays and maps are JSON objects.
+//
+// Fields tagged redact hold credentials or secrets and are masked in
+// logConfig's summary.
+type Config struct {
+	Region          string `yaml:"region" env:"AWS_REGION"`
+	Bucket          string `yaml:"bucket" env:"AWS_BUCKET_NAME"`
+	AccessKeyID     string `yaml:"accessKeyId" env:"AWS_ACCESS_KEY_ID" redact:"true"`
+	SecretAccessKey string `yaml:"secretAccessKey" env:"AWS_SECRET_ACCESS_KEY" redact:"true"`
+	Profile         string `yaml:"profile" env:"AWS_PROFILE"`
+
+	// BucketRegions maps buckets outside Region to the region they live in
+	BucketRegions map[string]string `yaml:"bucketRegions" env:"BUCKET_REGIONS"`
+
+	UserAgentProduct string `yaml:"userAgentProduct" env:"USER_AGENT_PRODUCT"`
+
+	RequireAccessLogging bool `yaml:"requireAccessLogging" env:"REQUIRE_ACCESS_LOGGING"`
+	AccessLoggingStrict  bool `yaml:"accessLoggingStrict" env:"ACCESS_LOGGING_STRICT"`
+
+	PresignClockSkew       time.Duration `yaml:"presignClockSkew" env:"PRESIGN_CLOCK_SKEW"`
+	UploadExpiry           time.Duration `yaml:"uploadExpiry" env:"UPLOAD_EXPIRY"`
+	DownloadExpiry         time.Duration `yaml:"downloadExpiry" env:"DOWNLOAD_EXPIRY"`
+	HeadExpiry             time.Duration `yaml:"headExpiry" env:"HEAD_EXPIRY"`
+	PresignExpiryLimit     time.Duration `yaml:"presignExpiryLimit" env:"PRESIGN_EXPIRY_LIMIT"`
+	PresignHost            string        `yaml:"presignHost" env:"PRESIGN_HOST"`
+	PresignSignedHeaders   []string      `yaml:"presignSignedHeaders" env:"PRESIGN_SIGNED_HEADERS"`
+	PresignUnsignedPayload bool          `yaml:"presignUnsignedPayload" env:"PRESIGN_UNSIGNED_PAYLOAD"`
+	IdempotencyTTL         time.Duration `yaml:"idempotencyTTL" env:"IDEMPOTENCY_TTL"`
+	CompleteTimeout        time.Duration `yaml:"completeTimeout" env:"COMPLETE_TIMEOUT"`
+	MaxParts               int           `yaml:"maxParts" env:"MAX_PARTS"`
+
+	MaxBatchParts           int `yaml:"maxBatchParts" env:"MAX_BATCH_PARTS"`
+	BatchPresignConcurrency int `yaml:"batchPresignConcurrency" env:"BATCH_PRESIGN_CONCURRENCY"`
+
+	MaxSizeByExtension     map[string]int64  `yaml:"maxSizeByExtension" env:"MAX_SIZE_BY_EXTENSION"`
+	MaxSizeByExtensionFile string            `yaml:"maxSizeByExtensionFile" env:"MAX_SIZE_BY_EXTENSION_FILE"`
+	FilenamePattern        string            `yaml:"filenamePattern" env:"FILENAME_PATTERN"`
+	LowercaseKeys          bool              `yaml:"lowercaseKeys" env:"LOWERCASE_KEYS"`
+	NFCFilenames           bool              `yaml:"nfcFilenames" env:"NFC_FILENAMES"`
+	BlockedKeys            []string          `yaml:"blockedKeys" env:"BLOCKED_KEYS"`
+	AttachmentExtensions   []string          `yaml:"attachmentExtensions" env:"ATTACHMENT_EXTENSIONS"`
+	KeyHashSecret          string            `yaml:"keyHashSecret" env:"KEY_HASH_SECRET" redact:"true"`
+	MaxFilenameLength      int               `yaml:"maxFilenameLength" env:"MAX_FILENAME_LENGTH"`
+	PublishPrefix          string            `yaml:"publishPrefix" env:"PUBLISH_PREFIX"`
+	DefaultMetadata        map[string]string `yaml:"defaultMetadata" env:"DEFAULT_METADATA"`
+	DefaultTags            map[string]string `yaml:"defaultTags" env:"DEFAULT_TAGS"`
+
+	UploadCategories   map[string]map[string]string `yaml:"uploadCategories" env:"UPLOAD_CATEGORIES"`
+	ContentTypeAliases map[string]string            `yaml:"contentTypeAliases" env:"CONTENT_TYPE_ALIASES"`
+
+	TrustedProxies        []string `yaml:"trustedProxies" env:"TRUSTED_PROXIES"`
+	AllowedOrigins        []string `yaml:"allowedOrigins" env:"ALLOWED_ORIGINS"`
+	NotificationTargetARN string   `yaml:"notificationTargetArn" env:"NOTIFICATION_TARGET_ARN"`
+	ConfirmWebhookURL     string   `yaml:"confirmWebhookUrl" env:"CONFIRM_WEBHOOK_URL" redact:"true"`
+	AdminToken            string   `yaml:"adminToken" env:"ADMIN_TOKEN" redact:"true"`
+	MaintenanceMode       bool     `yaml:"maintenanceMode" env:"MAINTENANCE_MODE"`
+
+	UserTokens   map[string]string `yaml:"userTokens" env:"USER_TOKENS" redact:"true"`
+	UserPrefixes bool              `yaml:"userPrefixes" env:"USER_PREFIXES"`
+	QuotaTiers   map[string]int64  `yaml:"quotaTiers" env:"QUOTA_TIERS"`
+	UserTiers    map[string]string `yaml:"userTiers" env:"USER_TIERS"`
+	QuotaWindow  time.Duration     `yaml:"quotaWindow" env:"QUOTA_WINDOW"`
+
+	StatsCacheTTL time.Duration `yaml:"statsCacheTTL" env:"STATS_CACHE_TTL"`
+	StatsMaxPages int           `yaml:"statsMaxPages" env:"STATS_MAX_PAGES"`
+
+	CloudFrontDistributionID string        `yaml:"cloudFrontDistributionId" env:"CLOUDFRONT_DISTRIBUTION_ID"`
+	CloudFrontBatchWindow    time.Duration `yaml:"cloudFrontBatchWindow" env:"CLOUDFRONT_BATCH_WINDOW"`
+
+	UploadPartSize           int64  `yaml:"uploadPartSize" env:"UPLOAD_PART_SIZE"`
+	UploadConcurrency        int    `yaml:"uploadConcurrency" env:"UPLOAD_CONCURRENCY"`
+	UploadMultipartThreshold int64  `yaml:"uploadMultipartThreshold" env:"UPLOAD_MULTIPART_THRESHOLD"`
+	VerifyContentType        bool   `yaml:"verifyContentType" env:"VERIFY_CONTENT_TYPE"`
+	UploadCollision          string `yaml:"uploadCollision" env:"UPLOAD_COLLISION"`
+	RequireContentLength     bool   `yaml:"requireContentLength" env:"REQUIRE_CONTENT_LENGTH"`
+	ProxyRateLimit           int    `yaml:"proxyRateLimitBytesPerSec" env:"PROXY_RATE_LIMIT_BYTES_PER_SEC"`
+	CompressQuality          int    `yaml:"compressQuality" env:"COMPRESS_QUALITY"`
+	CompressMinSize          int64  `yaml:"compressMinSize" env:"COMPRESS_MIN_SIZE"`
+	CompressMaxSize          int64  `yaml:"compressMaxSize" env:"COMPRESS_MAX_SIZE"`
+
+	StorageClassRules []storageClassRule `yaml:"storageClassRules" env:"STORAGE_CLASS_RULES"`
+
+	RestoreTier string `yaml:"restoreTier" env:"RESTORE_TIER"`
+	RestoreDays int    `yaml:"restoreDays" env:"RESTORE_DAYS"`
+
+	HeadCacheSize int           `yaml:"headCacheSize" env:"HEAD_CACHE_SIZE"`
+	HeadCacheTTL  time.Duration `yaml:"headCacheTTL" env:"HEAD_CACHE_TTL"`
+
+	MaxTranscodeSourceSize int64 `yaml:"maxTranscodeSourceSize" env:"MAX_TRANSCODE_SOURCE_SIZE"`
+
+	SSEBucketKey bool `yaml:"sseBucketKey" env:"SSE_BUCKET_KEY"`
+
+	DefaultACL       string `yaml:"defaultAcl" env:"DEFAULT_ACL"`
+	GrantFullControl string `yaml:"grantFullControl" env:"GRANT_FULL_CONTROL"`
+	GrantRead        string `yaml:"grantRead" env:"GRANT_READ"`
+	GrantReadACP     string `yaml:"grantReadAcp" env:"GRANT_READ_ACP"`
+	GrantWriteACP    string `yaml:"grantWriteAcp" env:"GRANT_WRITE_ACP"`
+
+	// Left unset, published copies get DefaultACL and the Grant* grants
+	PublishACL              string `yaml:"publishAcl" env:"PUBLISH_ACL"`
+	PublishGrantFullControl string `yaml:"publishGrantFullControl" env:"PUBLISH_GRANT_FULL_CONTROL"`
+	PublishGrantRead        string `yaml:"publishGrantRead" env:"PUBLISH_GRANT_READ"`
+	PublishGrantReadACP     string `yaml:"publishGrantReadAcp" env:"PUBLISH_GRANT_READ_ACP"`
+	PublishGrantWriteACP    string `yaml:"publishGrantWriteAcp" env:"PUBLISH_GRANT_WRITE_ACP"`
+
+	SingleUseNonces bool          `yaml:"singleUseNonces" env:"SINGLE_USE_NONCES"`
+	NonceTTL        time.Duration `yaml:"nonceTTL" env:"NONCE_TTL"`
+
+	AuditLogFile string `yaml:"auditLogFile" env:"AUDIT_LOG_FILE"`
+
+	ReadyCacheTTL     time.Duration `yaml:"readyCacheTTL" env:"READY_CACHE_TTL"`
+	ListPartsCacheTTL time.Duration `yaml:"listPartsCacheTTL" env:"LIST_PARTS_CACHE_TTL"`
+
+	// Zero leaves the number of in-flight requests unlimited
+	MaxConcurrentRequests int `yaml:"maxConcurrentRequests" env:"MAX_CONCURRENT_REQUESTS"`
+
+	// Makes failing to register metrics fatal rather than logged
+	MetricsStrict bool `yaml:"metricsStrict" env:"METRICS_STRICT"`
+
+	// For testing clients only; both default to off
+	ChaosLatency   time.Duration `yaml:"chaosLatency" env:"CHAOS_LATENCY"`
+	ChaosErrorRate float64       `yaml:"chaosErrorRate" env:"CHAOS_ERROR_RATE"`
+
+	// Setting TLSCertFile and TLSKeyFile serves HTTPS instead of HTTP
+	TLSCertFile     string   `yaml:"tlsCertFile" env:"TLS_CERT_FILE"`
+	TLSKeyFile      string   `yaml:"tlsKeyFile" env:"TLS_KEY_FILE"`
+	TLSMinVersion   string   `yaml:"tlsMinVersion" env:"TLS_MIN_VERSION"`
+	TLSCipherSuites []string `yaml:"tlsCipherSuites" env:"TLS_CIPHER_SUITES"`
+
+	// A zero S3BreakerFailures disables the circuit breaker
+	S3BreakerFailures int           `yaml:"s3BreakerFailures" env:"S3_BREAKER_FAILURES"`
+	S3BreakerTimeout  time.Duration `yaml:"s3BreakerTimeout" env:"S3_BREAKER_TIMEOUT"`
+	S3BreakerProbes   int           `yaml:"s3BreakerProbes" env:"S3_BREAKER_PROBES"`
+
+	Features Features `yaml:"features"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		UserAgentProduct:         "s3-image",
+		PresignUnsignedPayload:   true,
+		IdempotencyTTL:           time.Hour,
+		UploadExpiry:             15 * time.Minute,
+		DownloadExpiry:           time.Hour,
+		HeadExpiry:               5 * time.Minute,
+		PresignExpiryLimit:       24 * time.Hour,
+		CompleteTimeout:          60 * time.Second,
+		MaxParts:                 maxPartNumber,
+		MaxBatchParts:            100,
+		BatchPresignConcurrency:  8,
+		PublishPrefix:            "published/",
+		QuotaWindow:              24 * time.Hour,
+		StatsCacheTTL:            5 * time.Minute,
+		StatsMaxPages:            100,
+		CloudFrontBatchWindow:    5 * time.Second,
+		UploadPartSize:           manager.DefaultUploadPartSize,
+		UploadConcurrency:        manager.DefaultUploadConcurrency,
+		UploadMultipartThreshold: 64 << 20,
+		UploadCollision:          collisionOverwrite,
+		CompressQuality:          85,
+		CompressMinSize:          256 << 10,
+		CompressMaxSize:          25 << 20,
+		NonceTTL:                 time.Hour,
+		RestoreTier:              "Standard",
+		RestoreDays:              7,
+		HeadCacheSize:            1000,
+		HeadCacheTTL:             30 * time.Second,
+		MaxTranscodeSourceSize:   25 << 20,
+		S3BreakerFailures:        5,
+		S3BreakerTimeout:         30 * time.Second,
+		S3BreakerProbes:          1,
+		ReadyCacheTTL:            30 * time.Second,
+		ListPartsCacheTTL:        10 * time.Second,
+		TLSMinVersion:            "1.2",
+		AttachmentExtensions:     []string{"svg", "svgz", "html", "htm", "xhtml", "xht", "xml"},
+		ContentTypeAliases: map[string]string{
+			"image/jpg":   "image/jpeg",
+			"image/pjpeg": "image/jpeg",
+			"image/x-png": "image/png",
+		},
+		Features: allFeatures(),
+	}
+}
+
+// loadConfig builds the effective configuration from the defaults, the file
+// at path when it is non-empty, and the environment, then validates it.
+func loadConfig(path string) (*Config, error) {
+	conf := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// YAML is a superset of JSON, so one decoder handles both formats
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(conf); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+	}
+
+	if err := conf.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// applyEnv overrides fields with the environment variable in their env tag,
+// when that variable is set and non-empty.
+func (c *Config) applyEnv() error {
+	return applyEnv(reflect.ValueOf(c).Elem())
+}
+
+// applyEnv walks the fields of the struct v, descending into nested structs
+// such as Features.
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.Struct {
+			if err := applyEnv(v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		name := t.Field(i).Tag.Get("env")
+		raw := getEnv(name, "")
+		if name == "" || raw == "" {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), raw); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setFromEnv(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			s := reflect.New(field.Type())
+			if err := json.Unmarshal([]byte(raw), s.Interface()); err != nil {
+				return err
+			}
+			field.Set(s.Elem())
+			return nil
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		m := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(raw), m.Interface()); err != nil {
+			return err
+		}
+		field.Set(m.Elem())
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// validate checks the settings that don't need any further parsing; the rest
+// are checked as main builds them.
+func (c *Config) validate() error {
+	switch {
+	case c.Bucket == "":
+		return errors.New("AWS_BUCKET_NAME must be set")
+	case (c.AccessKeyID == "") != (c.SecretAccessKey == ""):
+		return errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together")
+	case c.UserAgentProduct == "":
+		return errors.New("USER_AGENT_PRODUCT must not be empty")
+	case c.PresignClockSkew < 0:
+		return errors.New("PRESIGN_CLOCK_SKEW must be a non-negative duration")
+	case c.UploadExpiry < time.Second || c.UploadExpiry > maxPresignExpiry:
+		return errors.New("UPLOAD_EXPIRY must be between 1s and 7 days")
+	case c.DownloadExpiry < time.Second || c.DownloadExpiry > maxPresignExpiry:
+		return errors.New("DOWNLOAD_EXPIRY must be between 1s and 7 days")
+	case c.HeadExpiry < time.Second || c.HeadExpiry > maxPresignExpiry:
+		return errors.New("HEAD_EXPIRY must be between 1s and 7 days")
+	case c.PresignExpiryLimit < time.Second || c.PresignExpiryLimit > maxPresignExpiry:
+		return errors.New("PRESIGN_EXPIRY_LIMIT must be between 1s and 7 days")
+	case c.IdempotencyTTL <= 0:
+		return errors.New("IDEMPOTENCY_TTL must be positive")
+	case c.CompleteTimeout <= 0:
+		return errors.New("COMPLETE_TIMEOUT must be positive")
+	case c.MaxParts < 1 || c.MaxParts > maxPartNumber:
+		return fmt.Errorf("MAX_PARTS must be between 1 and %d", maxPartNumber)
+	case c.MaxBatchParts <= 0:
+		return errors.New("MAX_BATCH_PARTS must be a positive integer")
+	case c.BatchPresignConcurrency <= 0:
+		return errors.New("BATCH_PRESIGN_CONCURRENCY must be a positive integer")
+	case len(c.QuotaTiers) > 0 && len(c.UserTokens) == 0:
+		return errors.New("QUOTA_TIERS needs USER_TOKENS to identify users")
+	case c.UserPrefixes && len(c.UserTokens) == 0:
+		return errors.New("USER_PREFIXES needs USER_TOKENS to identify users")
+	case c.UserPrefixes && !validUserPrefixes(c.UserTokens):
+		return errors.New("USER_PREFIXES needs every user ID in USER_TOKENS to be non-empty and free of /")
+	case !strings.HasSuffix(c.PublishPrefix, "/") || strings.HasPrefix(c.PublishPrefix, keyPrefix):
+		return fmt.Errorf("PUBLISH_PREFIX must end in / and be outside %s", keyPrefix)
+	case c.QuotaWindow <= 0:
+		return errors.New("QUOTA_WINDOW must be positive")
+	case c.StatsMaxPages <= 0:
+		return errors.New("STATS_MAX_PAGES must be a positive integer")
+	case c.CloudFrontBatchWindow <= 0:
+		return errors.New("CLOUDFRONT_BATCH_WINDOW must be positive")
+	case c.UploadPartSize < manager.MinUploadPartSize:
+		return fmt.Errorf("UPLOAD_PART_SIZE must be at least %d bytes", manager.MinUploadPartSize)
+	case c.UploadConcurrency <= 0:
+		return errors.New("UPLOAD_CONCURRENCY must be a positive integer")
+	case c.UploadMultipartThreshold <= 0 || c.UploadMultipartThreshold > maxPutObjectSize:
+		return fmt.Errorf("UPLOAD_MULTIPART_THRESHOLD must be between 1 and %d bytes", maxPutObjectSize)
+	case c.ProxyRateLimit < 0:
+		return errors.New("PROXY_RATE_LIMIT_BYTES_PER_SEC must be a non-negative integer")
+	case c.CompressQuality < 1 || c.CompressQuality > 100:
+		return errors.New("COMPRESS_QUALITY must be between 1 and 100")
+	case c.CompressMinSize < 0 || c.CompressMaxSize < c.CompressMinSize:
+		return errors.New("COMPRESS_MIN_SIZE must be non-negative and COMPRESS_MAX_SIZE at least COMPRESS_MIN_SIZE")
+	case c.HeadCacheSize < 0:
+		return errors.New("HEAD_CACHE_SIZE must be a non-negative integer")
+	case c.MaxTranscodeSourceSize <= 0:
+		return errors.New("MAX_TRANSCODE_SOURCE_SIZE must be a positive integer")
+	case c.S3BreakerFailures < 0:
+		return errors.New("S3_BREAKER_FAILURES must be a non-negative integer")
+	case c.S3BreakerTimeout < time.Second:
+		return errors.New("S3_BREAKER_TIMEOUT must be at least 1s")
+	case c.S3BreakerProbes <= 0:
+		return errors.New("S3_BREAKER_PROBES must be a positive integer")
+	case (c.TLSCertFile == "") != (c.TLSKeyFile == ""):
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	case c.ChaosLatency < 0:
+		return errors.New("CHAOS_LATENCY must be a non-negative duration")
+	case c.ChaosErrorRate < 0 || c.ChaosErrorRate > 1:
+		return errors.New("CHAOS_ERROR_RATE must be between 0 and 1")
+	case c.MaxConcurrentRequests < 0:
+		return errors.New("MAX_CONCURRENT_REQUESTS must be a non-negative integer")
+	case c.ReadyCacheTTL < 0:
+		return errors.New("READY_CACHE_TTL must be a non-negative duration")
+	case c.UploadCollision != collisionOverwrite && c.UploadCollision != collisionReject && c.UploadCollision != collisionVersion:
+		return errors.New("UPLOAD_COLLISION must be overwrite, reject or version")
+	case c.SingleUseNonces && c.NonceTTL < c.UploadExpiry:
+		return errors.New("NONCE_TTL must be at least UPLOAD_EXPIRY, the lifetime of a /generate URL")
+	case c.KeyHashSecret != "" && len(c.KeyHashSecret) < 32:
+		return errors.New("KEY_HASH_SECRET must be at least 32 bytes")
+	case c.MaxFilenameLength < 0:
+		return errors.New("MAX_FILENAME_LENGTH must be a non-negative integer")
+	case c.ListPartsCacheTTL < 0:
+		return errors.New("LIST_PARTS_CACHE_TTL must be a non-negative duration")
+	}
+
+	if c.DefaultACL != "" {
+		if _, err := parseCannedACL(c.DefaultACL); err != nil {
+			return fmt.Errorf("invalid DEFAULT_ACL: %v", err)
+		}
+		if c.GrantFullControl != "" || c.GrantRead != "" || c.GrantReadACP != "" || c.GrantWriteACP != "" {
+			return errors.New("DEFAULT_ACL can't be combined with GRANT_* grants")
+		}
+	}
+	if c.PublishACL != "" {
+		if _, err := parseCannedACL(c.PublishACL); err != nil {
+			return fmt.Errorf("invalid PUBLISH_ACL: %v", err)
+		}
+		if c.PublishGrantFullControl != "" || c.PublishGrantRead != "" || c.PublishGrantReadACP != "" || c.PublishGrantWriteACP != "" {
+			return errors.New("PUBLISH_ACL can't be combined with PUBLISH_GRANT_* grants")
+		}
+	}
+	if _, err := parseRestoreTier(c.RestoreTier); err != nil {
+		return fmt.Errorf("invalid RESTORE_TIER: %v", err)
+	}
+	if _, err := parseRestoreDays(strconv.Itoa(c.RestoreDays)); err != nil {
+		return fmt.Errorf("invalid RESTORE_DAYS: %v", err)
+	}
+	for name, region := range c.BucketRegions {
+		switch {
+		case strings.HasPrefix(name, "arn:"):
+			return fmt.Errorf("invalid BUCKET_REGIONS: %s is an access point, whose ARN names its region", name)
+		case !regionNamePattern.MatchString(region):
+			return fmt.Errorf("invalid BUCKET_REGIONS: %q for bucket %s is not a region", region, name)
+		}
+	}
+	return nil
+}
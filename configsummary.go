@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"time"
+)
+
+// logConfig logs the configuration in effect after defaults, the config file
+// and the environment are applied, as one JSON line keyed by the config file
+// names, so operators can check that a setting took. Redacted fields only
+// show whether they are set.
+func logConfig(c *Config) {
+	summary := configSummary(reflect.ValueOf(*c))
+	summary["keyPrefix"] = keyPrefix
+	b, err := json.Marshal(summary)
+	if err != nil {
+		log.Printf("Error summarizing configuration: %v", err)
+		return
+	}
+	log.Printf("Effective configuration: %s", b)
+}
+
+func configSummary(v reflect.Value) map[string]any {
+	summary := make(map[string]any, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		name := field.Tag.Get("yaml")
+		switch {
+		case field.Tag.Get("redact") == "true":
+			summary[name] = ""
+			if !value.IsZero() {
+				summary[name] = "[redacted]"
+			}
+		case value.Kind() == reflect.Struct:
+			summary[name] = configSummary(value)
+		case value.Type() == reflect.TypeFor[time.Duration]():
+			summary[name] = value.Interface().(time.Duration).String()
+		default:
+			summary[name] = value.Interface()
+		}
+	}
+	return summary
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// confirmWebhook, from CONFIRM_WEBHOOK_URL, is sent a JSON POST for every
+// upload /confirm verifies.
+var confirmWebhook string
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func parseConfirmWebhook(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http or https URL", raw)
+	}
+	return u.String(), nil
+}
+
+// confirmGrace is how long after its URL expires /confirm can still charge
+// an upload /generate signed without a size, leaving time for a PUT that
+// started just before the expiry to finish.
+const confirmGrace = time.Hour
+
+// unsizedUploads remembers the keys /generate signed for users with a quota
+// but without a size, which it therefore couldn't charge, so /confirm charges
+// each of them once the size is known. Uploads signed for a size were charged
+// up front and are never charged again.
+type unsizedUploads struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+var uncharged = &unsizedUploads{entries: make(map[string]time.Time)}
+
+func (u *unsizedUploads) add(user, key string, expiry time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	for k, expiresAt := range u.entries {
+		if now.After(expiresAt) {
+			delete(u.entries, k)
+		}
+	}
+	u.entries[user+"\x00"+key] = now.Add(expiry + confirmGrace)
+}
+
+// take reports whether key was waiting to be charged to user, forgetting it
+// so it is only charged once.
+func (u *unsizedUploads) take(user, key string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	k := user + "\x00" + key
+	expiresAt, ok := u.entries[k]
+	delete(u.entries, k)
+	return ok && time.Now().Before(expiresAt)
+}
+
+// handleConfirm is called by clients after a presigned PUT succeeds, since
+// the upload goes straight to S3 and the server otherwise never hears of it.
+// It checks the object against the size and ETag the client expects, and
+// once verified charges uploads that weren't charged when their URL was
+// signed and notifies CONFIRM_WEBHOOK_URL.
+func handleConfirm(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Key  string `json:"key"`
+		Size *int64 `json:"size"`
+		ETag string `json:"eTag"`
+	}
+
+	var errs validationErrors
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		errs.add("body", fmt.Sprintf("invalid JSON: %v", err))
+		errs.respond(w)
+		return
+	}
+	switch {
+	case payload.Key == "":
+		errs.add("key", "is required")
+	case !strings.HasPrefix(payload.Key, keyPrefix):
+		errs.add("key", fmt.Sprintf("must be under %s", keyPrefix))
+	case userPrefixes && !strings.HasPrefix(payload.Key, userKeyPrefix(r)):
+		errs.add("key", fmt.Sprintf("must be under %s", userKeyPrefix(r)))
+	}
+	if payload.Size != nil && *payload.Size < 0 {
+		errs.add("size", "must not be negative")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	// The cache could still hold what the key held before this PUT
+	head, err := s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(payload.Key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking uploaded object: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to check uploaded object: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	objectHeads.invalidate(payload.Key)
+	invalidator.invalidate(payload.Key)
+
+	size, eTag := aws.ToInt64(head.ContentLength), aws.ToString(head.ETag)
+	sizeMatches := payload.Size == nil || *payload.Size == size
+	eTagMatches := payload.ETag == "" || unquoteETag(payload.ETag) == unquoteETag(eTag)
+	resp := map[string]any{
+		"key":         payload.Key,
+		"size":        size,
+		"eTag":        eTag,
+		"sizeMatches": sizeMatches,
+		"eTagMatches": eTagMatches,
+		"verified":    sizeMatches && eTagMatches,
+	}
+	if head.LastModified != nil {
+		resp["lastModified"] = head.LastModified.UTC().Format(time.RFC3339)
+	}
+	if !sizeMatches || !eTagMatches {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if user := userFrom(r.Context()); user != "" && uncharged.take(user, payload.Key) {
+		quotas.record(r.Context(), size)
+	}
+	if confirmWebhook != "" {
+		go notifyConfirmed(map[string]any{
+			"key":  payload.Key,
+			"size": size,
+			"eTag": eTag,
+			"user": userFrom(r.Context()),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// notifyConfirmed posts event to CONFIRM_WEBHOOK_URL. It runs without holding
+// up the /confirm response, so failures are only logged.
+func notifyConfirmed(event map[string]any) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding confirm webhook: %v", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, confirmWebhook, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error creating confirm webhook request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		log.Printf("Error calling confirm webhook: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Confirm webhook answered %s for %v", resp.Status, event["key"])
+	}
+}
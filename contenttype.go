@@ -0,0 +1,36 @@
+package main
+
+import (
+	"mime"
+	"strings"
+)
+
+// contentTypeAliases, from CONTENT_TYPE_ALIASES, maps non-standard media
+// types some clients send to their canonical form, such as image/jpg to
+// image/jpeg. Browsers can refuse to render an object stored under the
+// wrong one.
+var contentTypeAliases map[string]string
+
+// normalizeContentTypeAliases lowercases the table so lookups are
+// case-insensitive, as media types are.
+func normalizeContentTypeAliases(aliases map[string]string) map[string]string {
+	normalized := make(map[string]string, len(aliases))
+	for from, to := range aliases {
+		normalized[strings.ToLower(from)] = strings.ToLower(to)
+	}
+	return normalized
+}
+
+// canonicalContentType replaces an aliased media type with its canonical
+// form, keeping any parameters. It returns an error for a malformed value.
+func canonicalContentType(contentType string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+	canonical, ok := contentTypeAliases[mediaType]
+	if !ok {
+		return contentType, nil
+	}
+	return mime.FormatMediaType(canonical, params), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxPartNumber is the highest part number S3 accepts.
+const maxPartNumber = 10000
+
+var copyRangePattern = regexp.MustCompile(`^bytes=\d+-\d+$`)
+
+// validVersionID bounds what is accepted as an S3 version ID. They are
+// opaque, so this only rules out what can't be one: whitespace, non-ASCII and
+// anything over S3's 1024 byte limit. "null" is valid and names the version
+// written before versioning was enabled.
+func validVersionID(id string) bool {
+	return len(id) <= 1024 && versionIDPattern.MatchString(id)
+}
+
+var versionIDPattern = regexp.MustCompile(`^[!-~]+$`)
+
+// copySourceFor builds the URL-encoded "bucket/key" form S3 expects in
+// CopySource headers.
+func copySourceFor(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// copySourceVersion is copySourceFor pinned to one version of key, or the
+// latest when versionID is empty.
+func copySourceVersion(key, versionID string) string {
+	if versionID == "" {
+		return copySourceFor(key)
+	}
+	return copySourceFor(key) + "?versionId=" + url.QueryEscape(versionID)
+}
+
+// handleCopyPart fills a part of a multipart upload from an existing object
+// (or a byte range of it) with UploadPartCopy, so large objects can be
+// composed without the data passing through the client. In a versioned
+// bucket, sourceVersionId copies from that version of copySource rather than
+// whichever is latest by the time S3 reads it. Versioned buckets report the
+// source version S3 copied from as sourceVersionId, pinned or not; the
+// version of the assembled object comes from /multipart/complete.
+func handleCopyPart(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Key             string `json:"key"`
+		UploadId        string `json:"uploadId"`
+		PartNumber      int32  `json:"partNumber"`
+		CopySource      string `json:"copySource"`
+		CopySourceRange string `json:"copySourceRange"`
+		SourceVersionId string `json:"sourceVersionId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if payload.Key == "" || payload.UploadId == "" || payload.CopySource == "" {
+		http.Error(w, "Missing required fields (key, uploadId, partNumber, copySource)", http.StatusBadRequest)
+		return
+	}
+	if payload.PartNumber < 1 {
+		http.Error(w, "Invalid partNumber", http.StatusBadRequest)
+		return
+	}
+	if int(payload.PartNumber) > maxParts {
+		http.Error(w, fmt.Sprintf("partNumber exceeds the maximum of %d parts", maxParts), http.StatusBadRequest)
+		return
+	}
+	if prefix := userKeyPrefix(r); !strings.HasPrefix(payload.Key, prefix) || !strings.HasPrefix(payload.CopySource, prefix) {
+		http.Error(w, fmt.Sprintf("key and copySource must be under %s", prefix), http.StatusBadRequest)
+		return
+	}
+	if payload.CopySourceRange != "" && !copyRangePattern.MatchString(payload.CopySourceRange) {
+		http.Error(w, "Invalid copySourceRange", http.StatusBadRequest)
+		return
+	}
+	if payload.SourceVersionId != "" && !validVersionID(payload.SourceVersionId) {
+		http.Error(w, "Invalid sourceVersionId", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if payload.SourceVersionId == "" {
+		_, err = headObject(r.Context(), payload.CopySource)
+	} else {
+		// The head cache only holds latest versions
+		_, err = s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(payload.CopySource),
+			VersionId: aws.String(payload.SourceVersionId),
+		})
+	}
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		http.Error(w, "copySource not found", http.StatusNotFound)
+		return
+	}
+	// S3 answers 400 to a version ID it can't parse
+	if payload.SourceVersionId != "" && hasErrorCode(err, "BadRequest") {
+		http.Error(w, "Invalid sourceVersionId", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking copy source: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to check copy source: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	input := &s3.UploadPartCopyInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(payload.Key),
+		UploadId:   aws.String(payload.UploadId),
+		PartNumber: aws.Int32(payload.PartNumber),
+		CopySource: aws.String(copySourceVersion(payload.CopySource, payload.SourceVersionId)),
+	}
+	if payload.CopySourceRange != "" {
+		input.CopySourceRange = aws.String(payload.CopySourceRange)
+	}
+
+	resp, err := s3Client.UploadPartCopy(r.Context(), input)
+	if err != nil {
+		log.Printf("Error copying part: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to copy part: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var eTag string
+	if resp.CopyPartResult != nil {
+		eTag = aws.ToString(resp.CopyPartResult.ETag)
+	}
+
+	result := map[string]any{
+		"partNumber": payload.PartNumber,
+		"eTag":       eTag,
+	}
+	// Only versioned buckets report the version copied from
+	if resp.CopySourceVersionId != nil {
+		result["sourceVersionId"] = aws.ToString(resp.CopySourceVersionId)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
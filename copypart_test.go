@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// copyPartS3 answers the HeadObject and UploadPartCopy of /multipart/copy-part
+// and any CompleteMultipartUpload, recording the requests.
+func copyPartS3(t *testing.T) *[]*http.Request {
+	var requests []*http.Request
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		switch {
+		case r.Method == http.MethodPut:
+			w.Write([]byte(`<CopyPartResult><ETag>"e1"</ETag></CopyPartResult>`))
+		case r.Method == http.MethodPost:
+			w.Write([]byte(`<CompleteMultipartUploadResult/>`))
+		}
+	})
+	return &requests
+}
+
+func TestMaxParts(t *testing.T) {
+	copyPartS3(t)
+	setGlobal(t, &maxParts, 3)
+
+	completeBody := func(n int) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = fmt.Sprintf(`{"eTag":"e%d","partNumber":%d}`, i+1, i+1)
+		}
+		return `{"key":"` + keyPrefix + `big.bin","uploadId":"U1","parts":[` + strings.Join(parts, ",") + `]}`
+	}
+	copyBody := func(partNumber int) string {
+		return fmt.Sprintf(`{"key":"%sbig.bin","uploadId":"U1","partNumber":%d,"copySource":"%ssrc.bin"}`, keyPrefix, partNumber, keyPrefix)
+	}
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"presign the last part", http.MethodGet, "/multipart/presigned?filename=big.bin&uploadId=U1&partNumber=3", "", http.StatusOK},
+		{"presign past the last part", http.MethodGet, "/multipart/presigned?filename=big.bin&uploadId=U1&partNumber=4", "", http.StatusBadRequest},
+		{"batch up to the last part", http.MethodGet, "/multipart/presigned/batch?key=" + keyPrefix + "big.bin&uploadId=U1&start=2&count=2", "", http.StatusOK},
+		{"batch past the last part", http.MethodGet, "/multipart/presigned/batch?key=" + keyPrefix + "big.bin&uploadId=U1&start=3&count=2", "", http.StatusBadRequest},
+		{"complete all parts", http.MethodPost, "/multipart/complete", completeBody(3), http.StatusOK},
+		{"complete too many parts", http.MethodPost, "/multipart/complete", completeBody(4), http.StatusBadRequest},
+		{"copy the last part", http.MethodPost, "/multipart/copy-part", copyBody(3), http.StatusOK},
+		{"copy past the last part", http.MethodPost, "/multipart/copy-part", copyBody(4), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.method, tt.target, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestValidVersionID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY+MTRCxf3vjVBH40Nr8X8gdRQBpUMLUo", true},
+		{"null", true},
+		{"", false},
+		{"has space", false},
+		{"tab\there", false},
+		{"vérsion", false},
+		{strings.Repeat("a", 1024), true},
+		{strings.Repeat("a", 1025), false},
+	}
+	for _, tt := range tests {
+		if got := validVersionID(tt.id); got != tt.want {
+			t.Errorf("validVersionID(%.20q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestCopySourceVersion(t *testing.T) {
+	setGlobal(t, &bucket, "b")
+	tests := []struct {
+		name      string
+		key       string
+		versionID string
+		want      string
+	}{
+		{"latest", "uploads/a b.bin", "", "b/uploads/a%20b.bin"},
+		{"pinned", "uploads/a.bin", "v1", "b/uploads/a.bin?versionId=v1"},
+		{"version ID is escaped", "uploads/a.bin", "a+b/c=", "b/uploads/a.bin?versionId=a%2Bb%2Fc%3D"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := copySourceVersion(tt.key, tt.versionID); got != tt.want {
+				t.Errorf("copySourceVersion(%q, %q) = %q, want %q", tt.key, tt.versionID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyPartSourceVersion(t *testing.T) {
+	var head, copied *http.Request
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			head = r
+			if r.URL.Query().Get("versionId") == "garbled" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		case http.MethodPut:
+			copied = r
+			w.Header().Set("X-Amz-Copy-Source-Version-Id", "v1")
+			w.Write([]byte(`<CopyPartResult><ETag>"e1"</ETag></CopyPartResult>`))
+		}
+	})
+	body := func(versionID string) string {
+		return fmt.Sprintf(`{"key":"%sbig.bin","uploadId":"U1","partNumber":1,"copySource":"%ssrc.bin","sourceVersionId":%q}`, keyPrefix, keyPrefix, versionID)
+	}
+
+	rec := serve(t, http.MethodPost, "/multipart/copy-part", body("v1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	if got := head.URL.Query().Get("versionId"); got != "v1" {
+		t.Errorf("HeadObject versionId = %q, want v1", got)
+	}
+	if got, want := copied.Header.Get("X-Amz-Copy-Source"), copySourceVersion(keyPrefix+"src.bin", "v1"); got != want {
+		t.Errorf("x-amz-copy-source = %q, want %q", got, want)
+	}
+	var resp struct {
+		SourceVersionId string `json:"sourceVersionId"`
+	}
+	decodeJSON(t, rec, &resp)
+	if resp.SourceVersionId != "v1" {
+		t.Errorf("sourceVersionId = %q, want v1", resp.SourceVersionId)
+	}
+
+	for _, versionID := range []string{"has space", "garbled"} {
+		rec := serve(t, http.MethodPost, "/multipart/copy-part", body(versionID))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("sourceVersionId %q: status = %d, want 400; body %q", versionID, rec.Code, rec.Body)
+		}
+	}
+}
+
+// The object a copy-part feeds gets its new version when the upload
+// completes.
+func TestCompleteMultipartVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		versionID string
+	}{
+		{"versioned bucket", "v2"},
+		{"unversioned bucket", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.versionID != "" {
+					w.Header().Set("X-Amz-Version-Id", tt.versionID)
+				}
+				w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"c-1"</ETag></CompleteMultipartUploadResult>`))
+			})
+			rec := serve(t, http.MethodPost, "/multipart/complete", `{"key":"`+keyPrefix+`big.bin","uploadId":"U1","parts":[{"eTag":"e1","partNumber":1}]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("X-Version-Id"); got != tt.versionID {
+				t.Errorf("X-Version-Id = %q, want %q", got, tt.versionID)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// allowedOrigins, from ALLOWED_ORIGINS, are the browser origins allowed to
+// talk to the bucket directly with our presigned URLs.
+var allowedOrigins []string
+
+// bucketCORSRules lets browsers PUT to and GET/HEAD from presigned URLs.
+// ETag is exposed because multipart clients must read it from each part's
+// response to complete the upload.
+func bucketCORSRules() []types.CORSRule {
+	return []types.CORSRule{{
+		AllowedOrigins: allowedOrigins,
+		AllowedMethods: []string{"GET", "HEAD", "PUT"},
+		AllowedHeaders: []string{"*"},
+		ExposeHeaders:  []string{"ETag"},
+		MaxAgeSeconds:  aws.Int32(3000),
+	}}
+}
+
+// handleSetupCORS replaces the bucket's CORS configuration with one derived
+// from ALLOWED_ORIGINS.
+func handleSetupCORS(w http.ResponseWriter, r *http.Request) {
+	if len(allowedOrigins) == 0 {
+		http.Error(w, "ALLOWED_ORIGINS is not set", http.StatusBadRequest)
+		return
+	}
+
+	rules := bucketCORSRules()
+	_, err := s3Client.PutBucketCors(r.Context(), &s3.PutBucketCorsInput{
+		Bucket: aws.String(bucket),
+		CORSConfiguration: &types.CORSConfiguration{
+			CORSRules: rules,
+		},
+	})
+	if err != nil {
+		log.Printf("Error applying bucket CORS configuration: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to apply bucket CORS configuration: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("Applied bucket CORS configuration for origins %v", allowedOrigins)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"allowedOrigins": rules[0].AllowedOrigins,
+		"allowedMethods": rules[0].AllowedMethods,
+	})
+}
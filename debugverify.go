@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// amzDateFormat is the layout of X-Amz-Date.
+const amzDateFormat = "20060102T150405Z"
+
+type presignedURLReport struct {
+	Host          string    `json:"host"`
+	Bucket        string    `json:"bucket,omitempty"`
+	Key           string    `json:"key"`
+	Operation     string    `json:"operation,omitempty"`
+	Algorithm     string    `json:"algorithm"`
+	AccessKeyID   string    `json:"accessKeyId"`
+	Region        string    `json:"region"`
+	Service       string    `json:"service"`
+	SignedAt      time.Time `json:"signedAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	SignedHeaders []string  `json:"signedHeaders"`
+	HasSignature  bool      `json:"hasSignature"`
+	Valid         bool      `json:"valid"`
+	Problems      []string  `json:"problems,omitempty"`
+}
+
+// handleVerifyURL takes {"url": "..."} and reports what a presigned URL was
+// signed for and whether it is still within its validity window. The URL
+// travels in the body so it stays out of access logs, and the signature
+// itself never appears in the report. It can't tell whether the signature
+// is correct: only S3 can check that.
+func handleVerifyURL(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		URL string `json:"url"`
+	}
+	var errs validationErrors
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		errs.add("body", fmt.Sprintf("invalid JSON: %v", err))
+		errs.respond(w)
+		return
+	}
+	u, err := url.Parse(payload.URL)
+	switch {
+	case payload.URL == "":
+		errs.add("url", "is required")
+	case err != nil || !u.IsAbs():
+		errs.add("url", "must be an absolute URL")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inspectPresignedURL(u, time.Now()))
+}
+
+func inspectPresignedURL(u *url.URL, now time.Time) presignedURLReport {
+	// Query keys are matched case-insensitively, as clients sometimes
+	// lowercase them when copying URLs around
+	query := make(map[string]string)
+	for name, values := range u.Query() {
+		if len(values) > 0 {
+			query[strings.ToLower(name)] = values[0]
+		}
+	}
+
+	report := presignedURLReport{
+		Host:         u.Host,
+		Algorithm:    query["x-amz-algorithm"],
+		Operation:    query["x-id"],
+		HasSignature: query["x-amz-signature"] != "",
+	}
+	report.Bucket, report.Key = urlBucketAndKey(u)
+
+	if report.Algorithm != "AWS4-HMAC-SHA256" {
+		report.Problems = append(report.Problems, fmt.Sprintf("unexpected X-Amz-Algorithm %q", report.Algorithm))
+	}
+	if !report.HasSignature {
+		report.Problems = append(report.Problems, "missing X-Amz-Signature")
+	}
+
+	// X-Amz-Credential is <access key>/<date>/<region>/<service>/aws4_request
+	if scope := strings.Split(query["x-amz-credential"], "/"); len(scope) == 5 && scope[4] == "aws4_request" {
+		report.AccessKeyID = maskAccessKeyID(scope[0])
+		report.Region = scope[2]
+		report.Service = scope[3]
+	} else {
+		report.Problems = append(report.Problems, "malformed or missing X-Amz-Credential")
+	}
+
+	if h := query["x-amz-signedheaders"]; h != "" {
+		report.SignedHeaders = strings.Split(h, ";")
+	} else {
+		report.Problems = append(report.Problems, "missing X-Amz-SignedHeaders")
+	}
+
+	signedAt, dateErr := time.Parse(amzDateFormat, query["x-amz-date"])
+	if dateErr != nil {
+		report.Problems = append(report.Problems, "malformed or missing X-Amz-Date")
+	}
+	expires, expiresErr := strconv.Atoi(query["x-amz-expires"])
+	if expiresErr != nil || expires < 1 {
+		report.Problems = append(report.Problems, "malformed or missing X-Amz-Expires")
+	}
+	if dateErr == nil && expiresErr == nil {
+		report.SignedAt = signedAt
+		report.ExpiresAt = signedAt.Add(time.Duration(expires) * time.Second)
+		switch {
+		case now.Before(signedAt):
+			report.Problems = append(report.Problems, "signed in the future; check the signing host's clock")
+		case !now.Before(report.ExpiresAt):
+			report.Problems = append(report.Problems, fmt.Sprintf("expired %s ago", now.Sub(report.ExpiresAt).Round(time.Second)))
+		}
+	}
+
+	report.Valid = len(report.Problems) == 0
+	return report
+}
+
+// urlBucketAndKey splits an S3 URL into bucket and key, for both
+// virtual-hosted ("bucket.s3.region.amazonaws.com/key") and path-style
+// ("host/bucket/key") addressing. The bucket is empty when it can't be told
+// from the host, as with custom domains.
+func urlBucketAndKey(u *url.URL) (string, string) {
+	path := strings.TrimPrefix(u.Path, "/")
+	host := u.Hostname()
+	if i := strings.Index(host, ".s3."); i > 0 {
+		return host[:i], path
+	}
+	if i := strings.Index(host, ".s3-"); i > 0 {
+		return host[:i], path
+	}
+	if strings.HasPrefix(host, "s3.") || strings.HasPrefix(host, "s3-") || net.ParseIP(host) != nil || host == "localhost" {
+		bucket, key, _ := strings.Cut(path, "/")
+		return bucket, key
+	}
+	return "", path
+}
+
+// maskAccessKeyID keeps enough of an access key ID to tell keys apart in a
+// ticket without quoting it whole.
+func maskAccessKeyID(id string) string {
+	if len(id) <= 8 {
+		return strings.Repeat("*", len(id))
+	}
+	return id[:4] + strings.Repeat("*", len(id)-8) + id[len(id)-4:]
+}
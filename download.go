@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	languageTagPattern  = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+	cacheControlPattern = regexp.MustCompile(`^[A-Za-z-]+(=("[^"\x00-\x1f]*"|[A-Za-z0-9-]+))?$`)
+)
+
+// handleDownload presigns a GET for an uploaded object.
+//
+// The optional responseContentType, responseContentLanguage and
+// responseCacheControl parameters are signed into the URL as
+// response-content-type, response-content-language and response-cache-control,
+// so S3 returns them as the Content-Type, Content-Language and Cache-Control
+// headers of the GET in place of whatever is stored on the object. Files with
+// one of the attachmentExtensions are always served as an attachment instead.
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filename := query.Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	filename = userFilename(r, filename)
+	expiry, err := requestedExpiry(query, downloadExpiry)
+	if err != nil {
+		http.Error(w, "Invalid expires: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(keyPrefix + filename),
+	}
+
+	if v := query.Get("responseContentType"); v != "" {
+		if _, _, err := mime.ParseMediaType(v); err != nil {
+			http.Error(w, "Invalid responseContentType", http.StatusBadRequest)
+			return
+		}
+		input.ResponseContentType = aws.String(v)
+	}
+	if v := query.Get("responseContentLanguage"); v != "" {
+		if !validContentLanguage(v) {
+			http.Error(w, "Invalid responseContentLanguage", http.StatusBadRequest)
+			return
+		}
+		input.ResponseContentLanguage = aws.String(v)
+	}
+	if v := query.Get("responseCacheControl"); v != "" {
+		if !validCacheControl(v) {
+			http.Error(w, "Invalid responseCacheControl", http.StatusBadRequest)
+			return
+		}
+		input.ResponseCacheControl = aws.String(v)
+	}
+	forceAttachment(input, filename)
+
+	req, err := presignClient.PresignGetObject(context.TODO(), input, presignExpires(context.TODO(), expiry))
+	if err != nil {
+		log.Printf("Error generating presigned download URL: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to generate presigned download URL: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := validatePresignedURL(req.URL, keyPrefix+filename); err != nil {
+		log.Printf("Presigned download URL failed sanity check: %v", err)
+		http.Error(w, "Failed to generate presigned download URL", http.StatusInternalServerError)
+		return
+	}
+
+	auditPresign(r, "GetObject", keyPrefix+filename, req.URL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": req.URL,
+	})
+}
+
+// handleHead presigns a HEAD for an uploaded object so the browser can read
+// its size, content type and ETag without downloading the body.
+func handleHead(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	filename = userFilename(r, filename)
+	expiry, err := requestedExpiry(r.URL.Query(), headExpiry)
+	if err != nil {
+		http.Error(w, "Invalid expires: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req, err := presignClient.PresignHeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(keyPrefix + filename),
+	}, presignExpires(context.TODO(), expiry))
+	if err != nil {
+		log.Printf("Error generating presigned head URL: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to generate presigned head URL: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := validatePresignedURL(req.URL, keyPrefix+filename); err != nil {
+		log.Printf("Presigned head URL failed sanity check: %v", err)
+		http.Error(w, "Failed to generate presigned head URL", http.StatusInternalServerError)
+		return
+	}
+
+	auditPresign(r, "HeadObject", keyPrefix+filename, req.URL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": req.URL,
+	})
+}
+
+// handleDownloadStream proxies an object's body to the client. A Range header
+// is forwarded to S3 so players can seek, and partial responses are relayed as
+// 206 with S3's Content-Range.
+//
+// Responses always carry nosniff and a sandbox CSP, and objects stored as
+// HTML, SVG or XHTML are sent as attachments.
+func handleDownloadStream(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	filename = userFilename(r, filename)
+
+	// The body is served from our own origin, where a user-uploaded page
+	// could otherwise script against it: no sniffing a type, and no script
+	// even in what the browser renders
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(keyPrefix + filename),
+	}
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		input.Range = aws.String(rangeHeader)
+	}
+	attachment := forceAttachment(input, filename)
+
+	resp, err := s3Client.GetObject(r.Context(), input)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		var apiErr smithy.APIError
+		switch {
+		case errors.As(err, &noSuchKey):
+			http.Error(w, "Object not found", http.StatusNotFound)
+		case errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange":
+			http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		default:
+			log.Printf("Error fetching object: %v", err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to fetch object: %v", err), http.StatusInternalServerError)
+			}
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Cache-Control", "private, max-age=300")
+	if resp.ContentType != nil {
+		h.Set("Content-Type", *resp.ContentType)
+	}
+	if resp.ContentDisposition != nil {
+		h.Set("Content-Disposition", *resp.ContentDisposition)
+	}
+	// This is served from our own origin, so it mustn't rely on S3 having
+	// honored the response overrides
+	if attachment {
+		h.Set("Content-Type", aws.ToString(input.ResponseContentType))
+		h.Set("Content-Disposition", aws.ToString(input.ResponseContentDisposition))
+	}
+	// A stored type that runs script is downloaded whatever the extension
+	if scriptableType(h.Get("Content-Type")) {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Disposition", attachmentDisposition(filename))
+	}
+	if resp.ContentLength != nil {
+		h.Set("Content-Length", strconv.FormatInt(*resp.ContentLength, 10))
+	}
+	if resp.ETag != nil {
+		h.Set("ETag", *resp.ETag)
+	}
+	if resp.LastModified != nil {
+		h.Set("Last-Modified", resp.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	status := http.StatusOK
+	if resp.ContentRange != nil {
+		h.Set("Content-Range", *resp.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+
+	if _, err := io.Copy(w, throttle(r.Context(), resp.Body)); err != nil {
+		log.Printf("Error streaming object: %v", err)
+	}
+}
+
+// validContentLanguage accepts a comma separated list of language tags such as
+// "en-US, fr".
+func validContentLanguage(v string) bool {
+	for _, tag := range strings.Split(v, ",") {
+		if !languageTagPattern.MatchString(strings.TrimSpace(tag)) {
+			return false
+		}
+	}
+	return true
+}
+
+// validCacheControl accepts a comma separated list of directives such as
+// "public, max-age=3600".
+func validCacheControl(v string) bool {
+	for _, directive := range strings.Split(v, ",") {
+		if !cacheControlPattern.MatchString(strings.TrimSpace(directive)) {
+			return false
+		}
+	}
+	return true
+}
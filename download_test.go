@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDownloadStreamHeaders(t *testing.T) {
+	tests := []struct {
+		name            string
+		filename        string
+		storedType      string
+		wantType        string
+		wantDisposition string
+	}{
+		{"image", "cat.png", "image/png", "image/png", ""},
+		{"HTML", "page.txt", "text/html; charset=utf-8", "application/octet-stream", `attachment; filename=page.txt`},
+		{"SVG", "cat.png", "image/svg+xml", "application/octet-stream", `attachment; filename=cat.png`},
+		{"XHTML", "page", "application/xhtml+xml", "application/octet-stream", `attachment; filename=page`},
+		{"uppercase type", "page", "Text/HTML", "application/octet-stream", `attachment; filename=page`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.storedType)
+				w.Write([]byte("body"))
+			})
+			rec := serve(t, http.MethodGet, "/download/stream?filename="+tt.filename, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			h := rec.Header()
+			if got := h.Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+			}
+			if got := h.Get("Content-Security-Policy"); got != "sandbox" {
+				t.Errorf("Content-Security-Policy = %q, want sandbox", got)
+			}
+			if got := h.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := h.Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.wantDisposition)
+			}
+		})
+	}
+}
+
+// The headers are set before S3 answers, so errors carry them too.
+func TestDownloadStreamHeadersOnError(t *testing.T) {
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+	})
+	rec := serve(t, http.MethodGet, "/download/stream?filename=missing.html", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); got != "sandbox" {
+		t.Errorf("Content-Security-Policy = %q, want sandbox", got)
+	}
+}
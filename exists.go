@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// handleExists reports whether an uploaded object exists, and its size and
+// ETag when it does.
+func handleExists(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	filename = userFilename(r, filename)
+
+	head, err := headObject(r.Context(), keyPrefix+filename)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"exists": false,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking object: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to check object: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"exists": true,
+		"size":   aws.ToInt64(head.ContentLength),
+		"eTag":   aws.ToString(head.ETag),
+	})
+}
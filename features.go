@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"strings"
+)
+
+// Features switches optional endpoints on and off. A disabled endpoint is
+// never registered, so it answers 404 like any unknown path.
+type Features struct {
+	ProxyUpload   bool `yaml:"proxyUpload" env:"ENABLE_PROXY_UPLOAD"`
+	ProxyDownload bool `yaml:"proxyDownload" env:"ENABLE_PROXY_DOWNLOAD"`
+	Restore       bool `yaml:"restore" env:"ENABLE_RESTORE"`
+	Transcode     bool `yaml:"transcode" env:"ENABLE_TRANSCODE"`
+	Stats         bool `yaml:"stats" env:"ENABLE_STATS"`
+	CopyPart      bool `yaml:"copyPart" env:"ENABLE_COPY_PART"`
+	Purge         bool `yaml:"purge" env:"ENABLE_PURGE"`
+	Select        bool `yaml:"select" env:"ENABLE_SELECT"`
+	Metrics       bool `yaml:"metrics" env:"ENABLE_METRICS"`
+}
+
+var features Features
+
+func allFeatures() Features {
+	return Features{
+		ProxyUpload:   true,
+		ProxyDownload: true,
+		Restore:       true,
+		Transcode:     true,
+		Stats:         true,
+		CopyPart:      true,
+		Purge:         true,
+		Select:        true,
+		Metrics:       true,
+	}
+}
+
+// logFeatures lists the enabled and disabled features by their config names.
+func logFeatures(f Features) {
+	var enabled, disabled []string
+	v := reflect.ValueOf(f)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("yaml")
+		if v.Field(i).Bool() {
+			enabled = append(enabled, name)
+		} else {
+			disabled = append(disabled, name)
+		}
+	}
+	log.Printf("Features enabled: [%s], disabled: [%s]", strings.Join(enabled, " "), strings.Join(disabled, " "))
+}
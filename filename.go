@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// filenamePattern, when set from FILENAME_PATTERN, must match every
+// client-supplied filename.
+var filenamePattern *regexp.Regexp
+
+func compileFilenamePattern(raw string) (*regexp.Regexp, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	return regexp.Compile(raw)
+}
+
+func filenameAllowed(filename string) bool {
+	return filenamePattern == nil || filenamePattern.MatchString(filename)
+}
+
+// maxFilenameLength, from MAX_FILENAME_LENGTH, caps client-supplied
+// filenames at this many characters, well inside S3's 1024-byte key limit,
+// to keep keys readable. Zero means no cap.
+var maxFilenameLength int
+
+func filenameTooLong(filename string) bool {
+	return maxFilenameLength > 0 && utf8.RuneCountInString(filename) > maxFilenameLength
+}
+
+// blockedKeys, from BLOCKED_KEYS, are filenames clients may not write to, as
+// exact names or path.Match globs such as "*.php". A pattern ending in "/*"
+// blocks everything under that directory, however deep.
+var blockedKeys []string
+
+// compileBlockedKeys checks every pattern is a valid glob, so a typo fails
+// startup rather than silently blocking nothing.
+func compileBlockedKeys(patterns []string) ([]string, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%q: %v", pattern, err)
+		}
+	}
+	return patterns, nil
+}
+
+func keyBlocked(filename string) bool {
+	for _, pattern := range blockedKeys {
+		if ok, _ := path.Match(pattern, filename); ok {
+			return true
+		}
+		// Match the directory part against as many leading segments
+		if dir, ok := strings.CutSuffix(pattern, "/*"); ok {
+			segments := strings.Split(filename, "/")
+			n := strings.Count(dir, "/") + 1
+			if len(segments) > n {
+				if ok, _ := path.Match(dir, strings.Join(segments[:n], "/")); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// lowercaseKeys, set by LOWERCASE_KEYS, lowercases client-supplied filenames
+// before they become object keys, so "Photo.JPG" and "photo.jpg" can't end
+// up as two objects. It changes the key an upload is stored under, which is
+// why the write endpoints return the key they used. Reads take the filename
+// as given, so objects written before the setting was turned on stay
+// reachable.
+var lowercaseKeys bool
+
+// nfcFilenames, set by NFC_FILENAMES, converts client-supplied filenames to
+// Unicode NFC before they become object keys. macOS tends to send names in
+// NFD, so without it the same visible name can be two different keys
+// depending on where it was uploaded from. Like LOWERCASE_KEYS it only
+// applies to writes.
+var nfcFilenames bool
+
+// normalizeFilename applies NFC_FILENAMES and LOWERCASE_KEYS to a filename
+// from a write request.
+func normalizeFilename(filename string) string {
+	if nfcFilenames {
+		filename = norm.NFC.String(filename)
+	}
+	if lowercaseKeys {
+		return strings.ToLower(filename)
+	}
+	return filename
+}
+
+// keyHashSecret, from KEY_HASH_SECRET, makes the endpoints that start an
+// upload store it under the hex HMAC-SHA256 of its filename, keeping only
+// the extension, so listing the bucket doesn't give away the names users
+// chose. The hashed key is returned as usual and is what the client passes
+// as the filename from then on. The hash can't be reversed, so anything that
+// needs the original name must record it, e.g. in upload metadata.
+// Changing the secret doesn't move existing objects.
+var keyHashSecret []byte
+
+// hashedFilename returns the filename an upload of filename is stored
+// under: filename itself unless KEY_HASH_SECRET is set.
+func hashedFilename(filename string) string {
+	if keyHashSecret == nil {
+		return filename
+	}
+	mac := hmac.New(sha256.New, keyHashSecret)
+	mac.Write([]byte(filename))
+	return hex.EncodeToString(mac.Sum(nil)) + path.Ext(filename)
+}
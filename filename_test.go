@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestFilenamePattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		filename string
+		want     bool
+	}{
+		{"no pattern allows anything", "", "../../etc/passwd", true},
+		{"matching", `^[a-z0-9_-]+\.(jpg|png)$`, "cat_01.jpg", true},
+		{"wrong extension", `^[a-z0-9_-]+\.(jpg|png)$`, "cat.gif", false},
+		{"unanchored pattern matches anywhere", `\.jpg`, "a.jpg.exe", true},
+		{"path separators", `^[^/]+$`, "dir/cat.jpg", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, err := compileFilenamePattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setGlobal(t, &filenamePattern, pattern)
+			if got := filenameAllowed(tt.filename); got != tt.want {
+				t.Errorf("filenameAllowed(%q) with %q = %v, want %v", tt.filename, tt.pattern, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := compileFilenamePattern(`[`); err == nil {
+		t.Error("compileFilenamePattern(`[`) succeeded, want an error")
+	}
+}
+
+func TestGenerateFilenamePattern(t *testing.T) {
+	fakeS3(t, nil)
+	useFakePresigner(t)
+	pattern, _ := compileFilenamePattern(`^[a-z]+\.jpg$`)
+	setGlobal(t, &filenamePattern, pattern)
+
+	tests := []struct {
+		filename string
+		status   int
+	}{
+		{"cat.jpg", http.StatusOK},
+		{"Cat.JPG", http.StatusBadRequest},
+		{"cat.php", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			rec := serve(t, http.MethodGet, "/generate?"+url.Values{"filename": {tt.filename}}.Encode(), "")
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestFilenameTooLong(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		filename string
+		want     bool
+	}{
+		{"no cap", 0, strings.Repeat("a", 2000), false},
+		{"at the cap", 8, "abcd.txt", false},
+		{"over the cap", 8, "abcde.txt", true},
+		// Counted in characters, not bytes
+		{"multibyte at the cap", 8, "日本語こ.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &maxFilenameLength, tt.max)
+			if got := filenameTooLong(tt.filename); got != tt.want {
+				t.Errorf("filenameTooLong(%q) with MAX_FILENAME_LENGTH %d = %v, want %v", tt.filename, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxFilenameLengthEndpoints(t *testing.T) {
+	uploadS3(t)
+	useFakePresigner(t)
+	setGlobal(t, &maxFilenameLength, 8)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"generate", http.MethodGet, "/generate", ""},
+		{"upload", http.MethodPost, "/upload", "hello"},
+	}
+	for _, tt := range tests {
+		for filename, status := range map[string]int{"abcd.txt": http.StatusOK, "abcde.txt": http.StatusBadRequest} {
+			t.Run(tt.name+" "+filename, func(t *testing.T) {
+				rec := serve(t, tt.method, tt.path+"?"+url.Values{"filename": {filename}}.Encode(), tt.body)
+				if rec.Code != status {
+					t.Errorf("status = %d, want %d; body %q", rec.Code, status, rec.Body)
+				}
+			})
+		}
+	}
+}
+
+// "Café.jpg" with a combining accent, as macOS sends it, and precomposed.
+const nfdFilename, nfcFilename = "Cafe\u0301.jpg", "Caf\u00e9.jpg"
+
+func TestNormalizeFilename(t *testing.T) {
+	tests := []struct {
+		name      string
+		nfc       bool
+		lowercase bool
+		filename  string
+		want      string
+	}{
+		{"off", false, false, nfdFilename, nfdFilename},
+		{"NFD to NFC", true, false, nfdFilename, nfcFilename},
+		{"already NFC", true, false, nfcFilename, nfcFilename},
+		{"ASCII is unchanged", true, false, "photo.jpg", "photo.jpg"},
+		{"with LOWERCASE_KEYS", true, true, nfdFilename, "caf\u00e9.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &nfcFilenames, tt.nfc)
+			setGlobal(t, &lowercaseKeys, tt.lowercase)
+			if got := normalizeFilename(tt.filename); got != tt.want {
+				t.Errorf("normalizeFilename(%+q) = %+q, want %+q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+// Both spellings of a name must presign the same key.
+func TestGenerateNFCFilename(t *testing.T) {
+	fakeS3(t, nil)
+	setGlobal(t, &nfcFilenames, true)
+	for _, filename := range []string{nfdFilename, nfcFilename} {
+		rec := serve(t, http.MethodGet, "/generate?"+url.Values{"filename": {filename}}.Encode(), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+		}
+		if got, want := rec.Header().Get("X-Object-Key"), keyPrefix+nfcFilename; got != want {
+			t.Errorf("X-Object-Key for %+q = %+q, want %+q", filename, got, want)
+		}
+	}
+}
+
+// With LOWERCASE_KEYS the presigned key, not just the filename, is lowercase.
+func TestGenerateLowercaseKeys(t *testing.T) {
+	fakeS3(t, nil)
+	setGlobal(t, &lowercaseKeys, true)
+	p := useFakePresigner(t)
+
+	rec := serve(t, http.MethodGet, "/generate?filename=Cat.JPG", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	if got, want := rec.Header().Get("X-Object-Key"), keyPrefix+"cat.jpg"; got != want {
+		t.Errorf("X-Object-Key = %q, want %q", got, want)
+	}
+	if len(p.puts) != 1 || aws.ToString(p.puts[0].Key) != keyPrefix+"cat.jpg" {
+		t.Errorf("presigned %d PUTs, want one for %scat.jpg", len(p.puts), keyPrefix)
+	}
+}
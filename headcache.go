@@ -0,0 +1,120 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// headCache is a size-bounded LRU of HeadObject results with a TTL. Only
+// objects that exist are cached: uploads through presigned URLs bypass the
+// server, so a cached miss could hide an object long after it was created.
+type headCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type headCacheEntry struct {
+	key       string
+	head      *s3.HeadObjectOutput
+	expiresAt time.Time
+}
+
+// objectHeads is nil when HEAD_CACHE_SIZE is 0, which disables caching.
+var objectHeads *headCache
+
+func newHeadCache(size int, ttl time.Duration) *headCache {
+	return &headCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *headCache) cacheKey(key string) string {
+	return bucket + "/" + key
+}
+
+func (c *headCache) get(key string) (*s3.HeadObjectOutput, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[c.cacheKey(key)]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*headCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(el)
+		delete(c.entries, entry.key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.head, true
+}
+
+func (c *headCache) put(key string, head *s3.HeadObjectOutput) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := c.cacheKey(key)
+	if el, ok := c.entries[k]; ok {
+		el.Value = &headCacheEntry{key: k, head: head, expiresAt: time.Now().Add(c.ttl)}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[k] = c.order.PushFront(&headCacheEntry{key: k, head: head, expiresAt: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*headCacheEntry).key)
+	}
+}
+
+// invalidate drops key after our endpoints overwrite or delete it.
+func (c *headCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[c.cacheKey(key)]; ok {
+		c.order.Remove(el)
+		delete(c.entries, c.cacheKey(key))
+	}
+}
+
+// headObject returns the object's metadata, from the cache when possible.
+// The returned output is shared and must not be modified.
+func headObject(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	if head, ok := objectHeads.get(key); ok {
+		headCacheHits.Inc()
+		return head, nil
+	}
+	headCacheMisses.Inc()
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	objectHeads.put(key, head)
+	return head, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// idempotencyCache remembers the multipart upload created for an
+// Idempotency-Key so that client retries of /multipart/initiate get the same
+// uploadId back instead of leaving orphaned uploads behind.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	uploadId  string
+	key       string
+	expiresAt time.Time
+}
+
+var initiateCache *idempotencyCache
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]idempotencyEntry),
+	}
+}
+
+// cacheKey scopes the client supplied key to the object key so the same
+// Idempotency-Key reused for a different file starts a new upload.
+func (c *idempotencyCache) cacheKey(idempotencyKey, filename string) string {
+	return idempotencyKey + "\x00" + filename
+}
+
+func (c *idempotencyCache) get(idempotencyKey, filename string) (idempotencyEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := c.cacheKey(idempotencyKey, filename)
+	entry, ok := c.entries[k]
+	if !ok {
+		return idempotencyEntry{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, k)
+		return idempotencyEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *idempotencyCache) put(idempotencyKey, filename, uploadId, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[c.cacheKey(idempotencyKey, filename)] = idempotencyEntry{
+		uploadId:  uploadId,
+		key:       key,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// evictUpload drops any entry pointing at uploadId, used once the upload has
+// been completed and retrying the initiate call should start a new one.
+func (c *idempotencyCache) evictUpload(uploadId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, entry := range c.entries {
+		if entry.uploadId == uploadId {
+			delete(c.entries, k)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// imageURLExpiry is short since the URL is followed straight away.
+const imageURLExpiry = 5 * time.Minute
+
+// handleImage redirects to a freshly presigned GET for an object, so pages
+// can embed <img src="/img?filename=..."> on our domain. The object is
+// checked first so a missing one is a 404 here rather than S3's XML error.
+// The redirect itself must not be cached: a cached one would keep pointing
+// at a URL after it expired. Files with ATTACHMENT_EXTENSIONS are served as
+// attachments, as by /download.
+func handleImage(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	filename = userFilename(r, filename)
+	key := keyPrefix + filename
+
+	_, err := headObject(r.Context(), key)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking object: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to check object: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	// An SVG or HTML upload would otherwise render, script and all, when
+	// followed outside an <img> tag
+	forceAttachment(input, filename)
+	req, err := presignClient.PresignGetObject(r.Context(), input, presignExpires(r.Context(), imageURLExpiry))
+	if err != nil {
+		log.Printf("Error generating presigned image URL: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to generate presigned image URL: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := validatePresignedURL(req.URL, key); err != nil {
+		log.Printf("Presigned image URL failed sanity check: %v", err)
+		http.Error(w, "Failed to generate presigned image URL", http.StatusInternalServerError)
+		return
+	}
+
+	auditPresign(r, "GetObject", key, req.URL)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, req.URL, http.StatusFound)
+}
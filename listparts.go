@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// partsCache briefly remembers which parts of a multipart upload S3 has, so
+// a client resuming an upload and asking about each part in turn costs one
+// ListParts rather than one per part.
+type partsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]partsEntry
+}
+
+type partsEntry struct {
+	parts     map[int32]bool
+	expiresAt time.Time
+}
+
+var uploadedParts *partsCache
+
+func newPartsCache(ttl time.Duration) *partsCache {
+	return &partsCache{
+		ttl:     ttl,
+		entries: make(map[string]partsEntry),
+	}
+}
+
+// has reports whether S3 already holds the given part of the upload.
+func (c *partsCache) has(ctx context.Context, key, uploadId string, partNumber int32) (bool, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[uploadId]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.parts[partNumber], nil
+	}
+
+	parts := make(map[int32]bool)
+	paginator := s3.NewListPartsPaginator(s3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, err
+		}
+		for _, part := range page.Parts {
+			parts[aws.ToInt32(part.PartNumber)] = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[uploadId] = partsEntry{parts: parts, expiresAt: now.Add(c.ttl)}
+	return parts[partNumber], nil
+}
+
+// evict drops a finished upload's parts.
+func (c *partsCache) evict(uploadId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, uploadId)
+}
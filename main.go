@@ -3,77 +3,592 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 var s3Client *s3.Client
+var presignClient presigner
 var bucket string
 var region string
+var completeTimeout time.Duration
+
+// maxParts caps part numbers per multipart upload, at most S3's own limit.
+var maxParts = maxPartNumber
+
+// keyPrefix is where every object handled by this service lives in the bucket.
+const keyPrefix = "uploads/"
 
 func main() {
-	// Load environment variables first
-	region = getEnv("AWS_REGION", "")
-	bucket = getEnv("AWS_BUCKET_NAME", "")
+	configPath := flag.String("config", getEnv("CONFIG_FILE", ""), "path to a YAML or JSON config file")
+	selfTest := flag.Bool("selftest", false, "upload, download and delete a test object, then exit")
+	flag.Parse()
 
-	if region == "" || bucket == "" {
-		log.Fatal("AWS_REGION and AWS_BUCKET_NAME must be set")
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
 	}
+	logConfig(conf)
+	region = conf.Region
+	bucket = conf.Bucket
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(region),
-		config.WithCredentialsProvider(
-			aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
-				getEnv("AWS_ACCESS_KEY_ID", ""),
-				getEnv("AWS_SECRET_ACCESS_KEY", ""),
-				"",
-			)),
-		),
-	)
+	awsCfg, credentialSource, err := loadAWSConfig(context.TODO(), conf)
 	if err != nil {
 		log.Fatalf("Unable to load SDK config, %v", err)
 	}
+	log.Printf("Using AWS credentials from %s", credentialSource)
+
+	accessPoint, err := parseAccessPointARN(bucket)
+	if err != nil {
+		log.Fatalf("Invalid AWS_BUCKET_NAME: %v", err)
+	}
+	if accessPoint != nil {
+		log.Printf("Using S3 access point %s", bucket)
+	} else {
+		log.Printf("Using bucket %s", bucket)
+	}
+
+	// AWS_REGION may also come from the shared config, and failing that
+	// from the bucket itself
+	if region == "" {
+		region = awsCfg.Region
+	}
+	if region == "" {
+		region, err = discoverRegion(context.TODO(), awsCfg, accessPoint)
+		if err != nil {
+			log.Fatalf("AWS_REGION is not set and the region of bucket %s could not be discovered: %v", bucket, err)
+		}
+		log.Printf("AWS_REGION is not set; using region %s discovered for bucket %s", region, bucket)
+	}
+	awsCfg.Region = region
+
+	bucketRegions = conf.BucketRegions
+	if len(bucketRegions) > 0 {
+		if err := checkBucketRegions(context.TODO(), awsCfg); err != nil {
+			log.Fatalf("Invalid BUCKET_REGIONS: %v", err)
+		}
+	}
+
+	s3Client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		// Calls on a bucket outside AWS_REGION are signed for its own region
+		if r := bucketRegions[bucket]; r != "" {
+			o.Region = r
+		}
+		// Sign for the access point's own region when it differs from AWS_REGION
+		if accessPoint != nil && accessPoint.Region != region {
+			o.UseARNRegion = true
+		}
+		if conf.S3BreakerFailures > 0 {
+			o.HTTPClient = newBreakerClient(o.HTTPClient, conf.S3BreakerFailures, conf.S3BreakerProbes, conf.S3BreakerTimeout)
+		}
+	})
+	whoami = newCallerIdentity(sts.NewFromConfig(awsCfg))
+	breakerTimeout = conf.S3BreakerTimeout
+	sseBucketKey = conf.SSEBucketKey
+	maxBatchParts = conf.MaxBatchParts
+	batchPresignConcurrency = conf.BatchPresignConcurrency
+	readiness = &readinessCheck{ttl: conf.ReadyCacheTTL}
+	lowercaseKeys = conf.LowercaseKeys
+	nfcFilenames = conf.NFCFilenames
+	maxFilenameLength = conf.MaxFilenameLength
+	if conf.KeyHashSecret != "" {
+		keyHashSecret = []byte(conf.KeyHashSecret)
+	}
+	publishPrefix = conf.PublishPrefix
+	proxyRateLimit = conf.ProxyRateLimit
+	compressQuality = conf.CompressQuality
+	compressMinSize = conf.CompressMinSize
+	compressMaxSize = conf.CompressMaxSize
+	features = conf.Features
+	logFeatures(features)
+	presigners = newRegionPresigners(s3Client, conf.PresignClockSkew)
+	presignClient = presigners.forBucket(bucket)
+	uploadExpiry = conf.UploadExpiry
+	downloadExpiry = conf.DownloadExpiry
+	headExpiry = conf.HeadExpiry
+	presignExpiryLimit = conf.PresignExpiryLimit
+	if conf.PresignHost != "" {
+		base, err := parsePresignHost(conf.PresignHost)
+		if err != nil {
+			log.Fatalf("Invalid PRESIGN_HOST: %v", err)
+		}
+		presignClient = hostRewritingPresigner{presigner: presignClient, base: base}
+		presignedHost = base.Host
+	} else {
+		presignedHost, err = endpointHost(context.TODO(), s3Client, bucket)
+		if err != nil {
+			log.Fatalf("Unable to resolve the S3 endpoint of %s: %v", bucket, err)
+		}
+	}
+
+	defaultACL, _ = parseCannedACL(conf.DefaultACL)
+	grants, err = newObjectGrants(conf.GrantFullControl, conf.GrantRead, conf.GrantReadACP, conf.GrantWriteACP)
+	if err != nil {
+		log.Fatalf("Invalid %v", err)
+	}
+	publishACL, _ = parseCannedACL(conf.PublishACL)
+	publishGrants, err = newObjectGrants(conf.PublishGrantFullControl, conf.PublishGrantRead, conf.PublishGrantReadACP, conf.PublishGrantWriteACP)
+	if err != nil {
+		log.Fatalf("Invalid PUBLISH_%v", err)
+	}
+	if publishACL == "" && !publishGrants.set() {
+		publishACL, publishGrants = defaultACL, grants
+	}
+
+	// Bucket-level settings can't be read through an access point
+	if accessPoint == nil {
+		checkAccessLogging(context.TODO(), conf.RequireAccessLogging, conf.AccessLoggingStrict)
+		checkObjectOwnership(context.TODO())
+	}
+
+	initiateCache = newIdempotencyCache(conf.IdempotencyTTL)
+	if conf.SingleUseNonces {
+		nonces = newNonceStore(conf.NonceTTL)
+	}
+	uploadedParts = newPartsCache(conf.ListPartsCacheTTL)
+
+	maxSizeByExtension, err = loadMaxSizeByExtension(conf.MaxSizeByExtension, conf.MaxSizeByExtensionFile)
+	if err != nil {
+		log.Fatalf("Invalid MAX_SIZE_BY_EXTENSION: %v", err)
+	}
+
+	filenamePattern, err = compileFilenamePattern(conf.FilenamePattern)
+	if err != nil {
+		log.Fatalf("Invalid FILENAME_PATTERN: %v", err)
+	}
+	blockedKeys, err = compileBlockedKeys(conf.BlockedKeys)
+	if err != nil {
+		log.Fatalf("Invalid BLOCKED_KEYS: %v", err)
+	}
+
+	defaultMetadata, err = normalizeMetadata(conf.DefaultMetadata)
+	if err != nil {
+		log.Fatalf("Invalid DEFAULT_METADATA: %v", err)
+	}
+	contentTypeAliases = normalizeContentTypeAliases(conf.ContentTypeAliases)
+	attachmentExtensions = newAttachmentExtensions(conf.AttachmentExtensions)
+	uploadCategories, err = newUploadCategories(conf.UploadCategories, conf.DefaultTags)
+	if err != nil {
+		log.Fatalf("Invalid tag configuration: %v", err)
+	}
+
+	signedHeaderAllowlist = parseSignedHeaderAllowlist(conf.PresignSignedHeaders)
+	presignUnsignedPayload = conf.PresignUnsignedPayload
+	trustedProxies, err = parseTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	bucketStats = newStatsCache(conf.StatsCacheTTL, conf.StatsMaxPages)
+	completeTimeout = conf.CompleteTimeout
+	maxParts = conf.MaxParts
+
+	uploader = newUploader(s3Client, conf.UploadPartSize, conf.UploadConcurrency)
+	multipartThreshold = conf.UploadMultipartThreshold
+	verifyContentType = conf.VerifyContentType
+	requireContentLength = conf.RequireContentLength
+	if err := validateStorageClassRules(conf.StorageClassRules); err != nil {
+		log.Fatalf("Invalid STORAGE_CLASS_RULES: %v", err)
+	}
+	storageClassRules = conf.StorageClassRules
+	uploadCollision = conf.UploadCollision
+
+	if conf.HeadCacheSize > 0 {
+		objectHeads = newHeadCache(conf.HeadCacheSize, conf.HeadCacheTTL)
+	}
+	maxTranscodeSourceSize = conf.MaxTranscodeSourceSize
+
+	adminToken = conf.AdminToken
+	setMaintenance(conf.MaintenanceMode)
+	if err := registerMetrics(); err != nil {
+		if conf.MetricsStrict {
+			log.Fatalf("Failed to register metrics: %v", err)
+		}
+		log.Printf("Error registering metrics, continuing without them: %v", err)
+	}
+	chaosLatency = conf.ChaosLatency
+	chaosErrorRate = conf.ChaosErrorRate
+	logChaos()
+	if conf.AuditLogFile != "" {
+		auditLog, err = newAuditLog(conf.AuditLogFile)
+		if err != nil {
+			log.Fatalf("Invalid AUDIT_LOG_FILE: %v", err)
+		}
+	}
+	tlsConfig, err := newTLSConfig(conf.TLSMinVersion, conf.TLSCipherSuites)
+	if err != nil {
+		log.Fatalf("Invalid TLS settings: %v", err)
+	}
+	userTokens = conf.UserTokens
+	userPrefixes = conf.UserPrefixes
+	quotas, err = newQuotaLimits(conf.QuotaTiers, conf.UserTiers, conf.QuotaWindow)
+	if err != nil {
+		log.Fatalf("Invalid quota configuration: %v", err)
+	}
+	allowedOrigins = conf.AllowedOrigins
+	if conf.ConfirmWebhookURL != "" {
+		confirmWebhook, err = parseConfirmWebhook(conf.ConfirmWebhookURL)
+		if err != nil {
+			log.Fatalf("Invalid CONFIRM_WEBHOOK_URL: %v", err)
+		}
+	}
+	if conf.NotificationTargetARN != "" {
+		notificationTarget, err = parseNotificationTarget(conf.NotificationTargetARN)
+		if err != nil {
+			log.Fatalf("Invalid NOTIFICATION_TARGET_ARN: %v", err)
+		}
+	}
 
-	s3Client = s3.NewFromConfig(cfg)
+	restoreTier, _ = parseRestoreTier(conf.RestoreTier)
+	restoreDays = int32(conf.RestoreDays)
 
-	http.HandleFunc("/generate", handleGenerate)
-	http.HandleFunc("/multipart/initiate", handleInitiateMultipart)
-	http.HandleFunc("/multipart/presigned", handlePresignPart)
-	http.HandleFunc("/multipart/complete", handleCompleteMultipart)
+	if conf.CloudFrontDistributionID != "" {
+		invalidator = newCDNInvalidator(cloudfront.NewFromConfig(awsCfg), conf.CloudFrontDistributionID, conf.CloudFrontBatchWindow)
+		log.Printf("CloudFront invalidation enabled for distribution %s", conf.CloudFrontDistributionID)
+	}
 
+	if *selfTest {
+		if !runSelfTest(context.TODO()) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	server := &http.Server{
+		Addr:      ":8080",
+		Handler:   logRequests(recoverPanics(injectChaos(limitConcurrency(conf.MaxConcurrentRequests, routes())))),
+		TLSConfig: tlsConfig,
+	}
+	if conf.TLSCertFile != "" {
+		log.Println("Server running on :8080 with TLS")
+		log.Fatal(server.ListenAndServeTLS(conf.TLSCertFile, conf.TLSKeyFile))
+	}
 	log.Println("Server running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(server.ListenAndServe())
+}
+
+// routes builds the mux serving every endpoint, kept off
+// http.DefaultServeMux so each caller gets an independent copy. Patterns carry
+// their method, so anything else gets a 405 with an Allow header.
+func routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /generate", pauseInMaintenance(withQuota(handleGenerate)))
+	mux.HandleFunc("GET /download", pauseInMaintenance(scopedToUser(handleDownload)))
+	mux.HandleFunc("GET /img", pauseInMaintenance(scopedToUser(handleImage)))
+	mux.HandleFunc("GET /head", pauseInMaintenance(scopedToUser(handleHead)))
+	mux.HandleFunc("GET /exists", scopedToUser(handleExists))
+	mux.HandleFunc("GET /metadata", scopedToUser(handleMetadata))
+	mux.HandleFunc("GET /multipart/initiate", pauseInMaintenance(withQuota(handleInitiateMultipart)))
+	mux.HandleFunc("POST /multipart/initiate", pauseInMaintenance(withQuota(handleInitiateMultipart)))
+	mux.HandleFunc("GET /multipart/plan", handlePlanMultipart)
+	mux.HandleFunc("GET /multipart/presigned", pauseInMaintenance(scopedToUser(handlePresignPart)))
+	mux.HandleFunc("GET /multipart/presigned/batch", pauseInMaintenance(scopedToUser(handlePresignPartBatch)))
+	mux.HandleFunc("GET /multipart/presigned/refresh", pauseInMaintenance(scopedToUser(handleRefreshPartURL)))
+	mux.HandleFunc("POST /multipart/complete", withUser(handleCompleteMultipart))
+	mux.HandleFunc("POST /confirm", withUser(handleConfirm))
+	mux.HandleFunc("GET /readyz", handleReady)
+	mux.HandleFunc("GET /healthz", handleHealth)
+	mux.HandleFunc("GET /version", handleVersion)
+	mux.HandleFunc("GET /ping", handlePing)
+	mux.HandleFunc("POST /admin/setup-cors", requireAdmin(handleSetupCORS))
+	mux.HandleFunc("POST /admin/setup-notifications", requireAdmin(handleSetupNotifications))
+	mux.HandleFunc("POST /debug/verify", requireAdmin(handleVerifyURL))
+	mux.HandleFunc("GET /whoami", requireAdmin(handleWhoami))
+	mux.HandleFunc("POST /admin/maintenance", requireAdmin(handleMaintenance))
+
+	if features.ProxyUpload {
+		mux.HandleFunc("POST /upload", pauseInMaintenance(withQuota(handleUpload)))
+	}
+	if features.ProxyDownload {
+		mux.HandleFunc("GET /download/stream", scopedToUser(handleDownloadStream))
+	}
+	if features.Restore {
+		mux.HandleFunc("POST /restore", scopedToUser(handleRestore))
+	}
+	if features.Transcode {
+		mux.HandleFunc("POST /transcode", pauseInMaintenance(scopedToUser(handleTranscode)))
+	}
+	if features.Stats {
+		mux.HandleFunc("GET /stats", handleStats)
+	}
+	if features.CopyPart {
+		mux.HandleFunc("POST /multipart/copy-part", pauseInMaintenance(scopedToUser(handleCopyPart)))
+	}
+	if features.Purge {
+		mux.HandleFunc("POST /admin/purge", requireAdmin(handlePurge))
+	}
+	if features.Select {
+		mux.HandleFunc("POST /select", scopedToUser(handleSelect))
+	}
+	if features.Metrics {
+		mux.Handle("GET /metrics", metricsHandler())
+	}
+	return jsonNotFound(mux)
 }
 
 func handleGenerate(w http.ResponseWriter, r *http.Request) {
-	filename := r.URL.Query().Get("filename")
+	filename := normalizeFilename(r.URL.Query().Get("filename"))
 	if filename == "" {
 		http.Error(w, "Missing filename", http.StatusBadRequest)
 		return
 	}
+	if filenameTooLong(filename) {
+		http.Error(w, fmt.Sprintf("Filename exceeds %d characters", maxFilenameLength), http.StatusBadRequest)
+		return
+	}
+	if !filenameAllowed(filename) {
+		http.Error(w, "Filename does not match the required pattern", http.StatusBadRequest)
+		return
+	}
+	if keyBlocked(filename) {
+		http.Error(w, "Filename is reserved", http.StatusForbidden)
+		return
+	}
+	filename = userFilename(r, hashedFilename(filename))
 
-	presignClient := s3.NewPresignClient(s3Client)
-	req, err := presignClient.PresignPutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String("uploads/" + filename),
-	}, s3.WithPresignExpires(15*time.Minute))
+	metadata, err := uploadMetadata(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	acl, err := objectACL(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tagging, err := categoryTagging(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:           aws.String(bucket),
+		Key:              aws.String(keyPrefix + filename),
+		Metadata:         metadata,
+		ACL:              acl,
+		GrantFullControl: grants.FullControl,
+		GrantRead:        grants.Read,
+		GrantReadACP:     grants.ReadACP,
+		GrantWriteACP:    grants.WriteACP,
+		Tagging:          tagging,
+		BucketKeyEnabled: bucketKeyEnabled(),
+	}
+
+	// The requested content type is normalized and echoed in X-Content-Type
+	// for the client to send with the PUT, since S3 stores whatever arrives
+	if v := r.URL.Query().Get("contentType"); v != "" {
+		contentType, err := canonicalContentType(v)
+		if err != nil {
+			http.Error(w, "Invalid contentType", http.StatusBadRequest)
+			return
+		}
+		input.ContentType = aws.String(contentType)
+	}
+
+	// With overwrite=false the URL is signed with If-None-Match: *, so the
+	// client must send that exact header on the PUT and S3 answers 412 if the
+	// key already exists
+	if v := r.URL.Query().Get("overwrite"); v != "" {
+		overwrite, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid overwrite", http.StatusBadRequest)
+			return
+		}
+		if !overwrite {
+			input.IfNoneMatch = aws.String("*")
+		}
+	}
+
+	// exactSize signs the size of fixed-size uploads such as generated
+	// thumbnails. The client must send a Content-Length header of exactly the
+	// value echoed in X-Content-Length, and S3 rejects the PUT otherwise
+	if v := r.URL.Query().Get("exactSize"); v != "" {
+		if r.URL.Query().Has("maxSize") || r.URL.Query().Has("size") {
+			http.Error(w, "exactSize can't be combined with maxSize or size", http.StatusBadRequest)
+			return
+		}
+		exactSize, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || exactSize <= 0 || exactSize > maxPutObjectSize {
+			http.Error(w, "Invalid exactSize", http.StatusBadRequest)
+			return
+		}
+		if limit, ok := maxSizeFor(filename); ok {
+			if exactSize > limit {
+				http.Error(w, fmt.Sprintf("exactSize exceeds the %d byte limit for this file type", limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			w.Header().Set("X-Upload-Max-Size", strconv.FormatInt(limit, 10))
+		}
+		input.ContentLength = aws.Int64(exactSize)
+	}
+
+	// Signing the declared size makes S3 reject bodies of any other length
+	if limit, ok := maxSizeFor(filename); ok && input.ContentLength == nil {
+		maxSizeStr := r.URL.Query().Get("maxSize")
+		if maxSizeStr == "" {
+			http.Error(w, "Missing maxSize parameter", http.StatusBadRequest)
+			return
+		}
+		maxSize, err := strconv.ParseInt(maxSizeStr, 10, 64)
+		if err != nil || maxSize <= 0 {
+			http.Error(w, "Invalid maxSize", http.StatusBadRequest)
+			return
+		}
+		if maxSize > limit {
+			http.Error(w, fmt.Sprintf("maxSize exceeds the %d byte limit for this file type", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		input.ContentLength = aws.Int64(maxSize)
+		w.Header().Set("X-Upload-Max-Size", strconv.FormatInt(limit, 10))
+	}
+
+	// REQUIRE_CONTENT_LENGTH signs every URL for a declared size, for bucket
+	// policies that refuse uploads of unknown length
+	if requireContentLength && input.ContentLength == nil {
+		sizeStr := r.URL.Query().Get("size")
+		if sizeStr == "" {
+			http.Error(w, "Missing size parameter", http.StatusBadRequest)
+			return
+		}
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || size <= 0 || size > maxPutObjectSize {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+		input.ContentLength = aws.Int64(size)
+	}
+
+	// The chosen class is signed as x-amz-storage-class and echoed in
+	// X-Storage-Class for the client to send with the PUT
+	if class, ok := storageClassFor(input.ContentType, input.ContentLength); ok {
+		input.StorageClass = class
+	}
+
+	expiry, err := requestedExpiry(r.URL.Query(), uploadExpiry)
+	if err != nil {
+		http.Error(w, "Invalid expires: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if nonces != nil {
+		// The nonce must be remembered for as long as the URL works
+		if expiry > nonces.ttl {
+			http.Error(w, fmt.Sprintf("Invalid expires: must not exceed NONCE_TTL of %d seconds", int(nonces.ttl.Seconds())), http.StatusBadRequest)
+			return
+		}
+		nonce := r.URL.Query().Get("nonce")
+		if !noncePattern.MatchString(nonce) {
+			http.Error(w, "nonce must be 16 to 128 letters, digits, '-' or '_'", http.StatusBadRequest)
+			return
+		}
+		err := nonces.claim(r.Context(), nonce, keyPrefix+filename)
+		if errors.Is(err, errNonceUsed) || errors.Is(err, errNonceOtherKey) {
+			http.Error(w, fmt.Sprintf("Refusing nonce: %v", err), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking nonce: %v", err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to check nonce: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	presignOpts := []func(*s3.PresignOptions){presignExpires(context.TODO(), expiry)}
+	if !presignUnsignedPayload {
+		hash := r.URL.Query().Get("contentSha256")
+		if hash == "" {
+			http.Error(w, "Missing contentSha256 parameter", http.StatusBadRequest)
+			return
+		}
+		if !sha256Pattern.MatchString(hash) {
+			http.Error(w, "Invalid contentSha256, expected a lowercase hex SHA-256", http.StatusBadRequest)
+			return
+		}
+		presignOpts = append(presignOpts, withPayloadHash(hash))
+	}
+
+	req, err := presignClient.PresignPutObject(context.TODO(), input, presignOpts...)
 
 	if err != nil {
 		log.Printf("Error generating presigned URL: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to generate presigned URL: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if err := validatePresignedURL(req.URL, keyPrefix+filename); err != nil {
+		log.Printf("Presigned URL failed sanity check: %v", err)
+		http.Error(w, "Failed to generate presigned URL", http.StatusInternalServerError)
+		return
+	}
 
+	// The client must send exactly these headers with the PUT
+	signedHeaders, err := clientSignedHeaders(req.SignedHeader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Uploads through the URL can take up to the declared size
+	if usage, ok := quotas.charge(r.Context(), aws.ToInt64(input.ContentLength)); !ok {
+		respondQuotaExceeded(w, usage)
+		return
+	}
+	// Without one, /confirm charges the upload once its size is known
+	if user := userFrom(r.Context()); user != "" && input.ContentLength == nil {
+		uncharged.add(user, keyPrefix+filename, expiry)
+	}
+
+	if input.ContentLength != nil {
+		declaredUploadSizes.Observe(float64(*input.ContentLength))
+	}
+	auditPresign(r, "PutObject", keyPrefix+filename, req.URL)
+	w.Header().Set("X-Object-Key", keyPrefix+filename)
+	w.Header().Set("X-Signed-Headers", strings.Join(signedHeaders, ";"))
+	if input.ContentType != nil {
+		w.Header().Set("X-Content-Type", *input.ContentType)
+	}
+	if input.ContentLength != nil {
+		w.Header().Set("X-Content-Length", strconv.FormatInt(*input.ContentLength, 10))
+	}
+	if input.StorageClass != "" {
+		w.Header().Set("X-Storage-Class", string(input.StorageClass))
+	}
+	if tagging != nil {
+		// The tags come from configuration, so the client can't know the
+		// x-amz-tagging value to send without being told
+		w.Header().Set("X-Object-Tagging", *tagging)
+	}
+	// The metadata includes DEFAULT_METADATA, which the client never sent.
+	// validateMetadata keeps it printable ASCII, so it is safe in a header
+	if len(input.Metadata) > 0 {
+		encoded, _ := json.Marshal(input.Metadata)
+		w.Header().Set("X-Object-Metadata", string(encoded))
+	}
+	// As may the ACL, when it is DEFAULT_ACL rather than the request's own
+	if input.ACL != "" {
+		w.Header().Set("X-Object-ACL", string(input.ACL))
+	}
+	// So do the grants, those the presigner didn't hoist into the query
+	for _, name := range signedGrantHeaders {
+		if v := req.SignedHeader.Get(name); v != "" {
+			w.Header().Set("X-Object-"+strings.TrimPrefix(name, "X-Amz-"), v)
+		}
+	}
 	fmt.Fprint(w, req.URL)
 }
 
@@ -86,84 +601,321 @@ func getEnv(key, fallback string) string {
 
 func handleInitiateMultipart(w http.ResponseWriter, r *http.Request) {
 	// Expect "key" parameter to match the frontend
-	filename := r.URL.Query().Get("key")
+	filename := normalizeFilename(r.URL.Query().Get("key"))
 	if filename == "" {
 		http.Error(w, "Missing key parameter", http.StatusBadRequest)
 		return
 	}
+	filename = userFilename(r, hashedFilename(filename))
+
+	// Retries carrying the same Idempotency-Key get the upload we already created
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		if entry, ok := initiateCache.get(idempotencyKey, filename); ok {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{
+				"uploadId": entry.uploadId,
+				"key":      entry.key,
+			})
+			return
+		}
+	}
+
+	metadata, err := uploadMetadata(r.URL.Query())
+	if err == nil {
+		// Metadata set at initiation ends up on the completed object
+		metadata, err = withOwner(metadata, r.URL.Query().Get("owner"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	acl, err := objectACL(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tagging, err := categoryTagging(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Parts of an upload created with a checksum algorithm carry checksums
+	// S3 checks, and need a version 2 complete
+	var checksumAlgorithm types.ChecksumAlgorithm
+	if v := r.URL.Query().Get("checksumAlgorithm"); v != "" {
+		if checksumAlgorithm, err = parseChecksumAlgorithm(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	input := &s3.CreateMultipartUploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String("uploads/" + filename),
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(keyPrefix + filename),
+		Metadata:          metadata,
+		ACL:               acl,
+		GrantFullControl:  grants.FullControl,
+		GrantRead:         grants.Read,
+		GrantReadACP:      grants.ReadACP,
+		GrantWriteACP:     grants.WriteACP,
+		Tagging:           tagging,
+		BucketKeyEnabled:  bucketKeyEnabled(),
+		ChecksumAlgorithm: checksumAlgorithm,
+	}
+
+	// With overwrite=false an existing object fails the initiate with 409,
+	// before any parts are uploaded. The check costs a HeadObject, so it is
+	// opt-in, and a key taken between it and the complete is still clobbered.
+	// It skips the HEAD cache, which could still say a key is free after
+	// another upload took it
+	if v := r.URL.Query().Get("overwrite"); v != "" {
+		overwrite, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid overwrite", http.StatusBadRequest)
+			return
+		}
+		if !overwrite {
+			_, err := s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(keyPrefix + filename),
+			})
+			var notFound *types.NotFound
+			switch {
+			case err == nil:
+				http.Error(w, "Object already exists", http.StatusConflict)
+				return
+			case !errors.As(err, &notFound):
+				log.Printf("Error checking for existing object: %v", err)
+				if !respondThrottled(w, err) {
+					http.Error(w, fmt.Sprintf("Failed to check for existing object: %v", err), http.StatusInternalServerError)
+				}
+				return
+			}
+		}
 	}
 
 	resp, err := s3Client.CreateMultipartUpload(context.TODO(), input)
 	if err != nil {
 		log.Printf("Error initiating multipart upload: %v", err)
+		if respondThrottled(w, err) {
+			return
+		}
 		http.Error(w, fmt.Sprintf("Failed to initiate multipart upload: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	if idempotencyKey != "" {
+		initiateCache.put(idempotencyKey, filename, *resp.UploadId, *resp.Key)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	body := map[string]string{
 		"uploadId": *resp.UploadId,
 		"key":      *resp.Key,
-	})
+	}
+	if checksumAlgorithm != "" {
+		body["checksumAlgorithm"] = string(checksumAlgorithm)
+	}
+	json.NewEncoder(w).Encode(body)
 }
 
 func handlePresignPart(w http.ResponseWriter, r *http.Request) {
-	filename := r.URL.Query().Get("filename")
+	filename := normalizeFilename(r.URL.Query().Get("filename"))
 	uploadId := r.URL.Query().Get("uploadId")
 	partNumStr := r.URL.Query().Get("partNumber")
 
-	if filename == "" || uploadId == "" || partNumStr == "" {
-		http.Error(w, "Missing required parameters (filename, uploadId, partNumber)", http.StatusBadRequest)
-		return
+	var errs validationErrors
+	if filename == "" {
+		errs.add("filename", "is required")
+	}
+	if uploadId == "" {
+		errs.add("uploadId", "is required")
 	}
-
 	partNumber, err := strconv.Atoi(partNumStr)
+	switch {
+	case partNumStr == "":
+		errs.add("partNumber", "is required")
+	case err != nil || partNumber < 1:
+		errs.add("partNumber", "must be a positive integer")
+	case partNumber > maxParts:
+		errs.add("partNumber", fmt.Sprintf("must not exceed %d", maxParts))
+	}
+	partSize := declaredPartSize(r.URL.Query(), &errs)
+	var checkExisting bool
+	if v := r.URL.Query().Get("checkExisting"); v != "" {
+		if checkExisting, err = strconv.ParseBool(v); err != nil {
+			errs.add("checkExisting", "must be a boolean")
+		}
+	}
+	expiry, err := requestedExpiry(r.URL.Query(), uploadExpiry)
 	if err != nil {
-		http.Error(w, "Invalid partNumber", http.StatusBadRequest)
+		errs.add("expires", err.Error())
+	}
+	checksums := partChecksumsFrom(r.URL.Query())
+	if _, err := checksums.checksumAlgorithm(); err != nil {
+		errs.add("checksum", err.Error())
+	}
+	if errs.respond(w) {
 		return
 	}
+	filename = userFilename(r, filename)
 
-	presignClient := s3.NewPresignClient(s3Client)
-	req, err := presignClient.PresignUploadPart(context.TODO(), &s3.UploadPartInput{
-		Bucket:     aws.String(bucket),
-		Key:        aws.String("uploads/" + filename),
-		PartNumber: aws.Int32(int32(partNumber)),
-		UploadId:   aws.String(uploadId),
-	}, s3.WithPresignExpires(15*time.Minute))
+	input := &s3.UploadPartInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(keyPrefix + filename),
+		PartNumber:    aws.Int32(int32(partNumber)),
+		UploadId:      aws.String(uploadId),
+		ContentLength: partSize,
+	}
+	checksums.applyUpload(input)
+	req, err := presignClient.PresignUploadPart(context.TODO(), input, presignExpires(context.TODO(), expiry))
 
 	if err != nil {
 		log.Printf("Error generating presigned part URL: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to generate presigned part URL: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if err := validatePresignedURL(req.URL, keyPrefix+filename); err != nil {
+		log.Printf("Presigned part URL failed sanity check: %v", err)
+		http.Error(w, "Failed to generate presigned part URL", http.StatusInternalServerError)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	resp := map[string]any{
 		"url": req.URL,
-	})
+	}
+	// Resuming clients can skip parts S3 already has. The URL is still
+	// returned, since uploading a part again just replaces it
+	if checkExisting {
+		uploaded, err := uploadedParts.has(r.Context(), keyPrefix+filename, uploadId, int32(partNumber))
+		if hasErrorCode(err, "NoSuchUpload") {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error listing parts: %v", err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to list uploaded parts: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+		if uploaded {
+			resp["alreadyUploaded"] = true
+		}
+	}
+
+	auditPresign(r, "UploadPart", keyPrefix+filename, req.URL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
 func handleCompleteMultipart(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
+		// Version picks the payload's shape, completePayloadV1 when absent
+		Version  int    `json:"version"`
 		Key      string `json:"key"`
 		UploadId string `json:"uploadId"`
 		Parts    []struct {
 			ETag       string `json:"eTag"`
 			PartNumber int32  `json:"partNumber"`
+			partChecksums
 		} `json:"parts"`
+		// Verify checks the parts against ListParts before completing
+		Verify bool `json:"verify"`
+		// Publish copies the completed object to publishPrefix
+		Publish bool `json:"publish"`
+		// MetadataDirective is COPY, the default, to publish with the
+		// upload's metadata, or REPLACE to publish with Metadata and
+		// ContentType instead
+		MetadataDirective string            `json:"metadataDirective"`
+		Metadata          map[string]string `json:"metadata"`
+		ContentType       string            `json:"contentType"`
 	}
 
+	var errs validationErrors
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		errs.add("body", fmt.Sprintf("invalid JSON: %v", err))
+		errs.respond(w)
 		return
 	}
 
-	if payload.Key == "" || payload.UploadId == "" || len(payload.Parts) == 0 {
-		http.Error(w, "Missing required fields (key, uploadId, parts)", http.StatusBadRequest)
+	if payload.Version == 0 {
+		payload.Version = completePayloadV1
+	}
+	if payload.Version != completePayloadV1 && payload.Version != completePayloadV2 {
+		errs.add("version", fmt.Sprintf("must be %d or %d", completePayloadV1, completePayloadV2))
+	}
+	switch {
+	case payload.Key == "":
+		errs.add("key", "is required")
+	case userPrefixes && !strings.HasPrefix(payload.Key, userKeyPrefix(r)):
+		errs.add("key", fmt.Sprintf("must be under %s", userKeyPrefix(r)))
+	case payload.Publish && !strings.HasPrefix(payload.Key, keyPrefix):
+		errs.add("key", fmt.Sprintf("must be under %s to publish", keyPrefix))
+	}
+	if payload.UploadId == "" {
+		errs.add("uploadId", "is required")
+	}
+	switch {
+	case len(payload.Parts) == 0:
+		errs.add("parts", "is required")
+	case len(payload.Parts) > maxParts:
+		errs.add("parts", fmt.Sprintf("must not have more than %d entries", maxParts))
+	}
+	for i, part := range payload.Parts {
+		if part.ETag == "" {
+			errs.add(fmt.Sprintf("parts[%d].eTag", i), "is required")
+		}
+		if part.PartNumber < 1 || int(part.PartNumber) > maxParts {
+			errs.add(fmt.Sprintf("parts[%d].partNumber", i), fmt.Sprintf("must be between 1 and %d", maxParts))
+		}
+	}
+	var checksumAlgorithm types.ChecksumAlgorithm
+	for i, part := range payload.Parts {
+		algorithm, err := part.checksumAlgorithm()
+		field := fmt.Sprintf("parts[%d]", i)
+		switch {
+		case err != nil:
+			errs.add(field, err.Error())
+		case payload.Version == completePayloadV1 && algorithm != "":
+			errs.add(field, fmt.Sprintf("checksums need version %d", completePayloadV2))
+		case payload.Version == completePayloadV2 && algorithm == "":
+			errs.add(field, "must have a checksum")
+		case checksumAlgorithm != "" && algorithm != checksumAlgorithm:
+			errs.add(field, "must use the same checksum algorithm as the other parts")
+		}
+		if checksumAlgorithm == "" {
+			checksumAlgorithm = algorithm
+		}
+	}
+	var replace *publishReplacement
+	switch types.MetadataDirective(strings.ToUpper(payload.MetadataDirective)) {
+	case "", types.MetadataDirectiveCopy:
+		if payload.Metadata != nil || payload.ContentType != "" {
+			errs.add("metadataDirective", "must be REPLACE to set metadata or contentType")
+		}
+	case types.MetadataDirectiveReplace:
+		replace = &publishReplacement{}
+		metadata, err := normalizeMetadata(payload.Metadata)
+		if err != nil {
+			errs.add("metadata", err.Error())
+		}
+		replace.metadata = metadata
+		if payload.ContentType != "" {
+			contentType, err := canonicalContentType(payload.ContentType)
+			if err != nil {
+				errs.add("contentType", "is not a valid media type")
+			}
+			replace.contentType = contentType
+		}
+	default:
+		errs.add("metadataDirective", "must be COPY or REPLACE")
+	}
+	if payload.MetadataDirective != "" && !payload.Publish {
+		errs.add("metadataDirective", "only applies when publishing")
+	}
+	if errs.respond(w) {
 		return
 	}
 
@@ -173,9 +925,57 @@ func handleCompleteMultipart(w http.ResponseWriter, r *http.Request) {
 			ETag:       aws.String(part.ETag),
 			PartNumber: aws.Int32(part.PartNumber),
 		}
+		part.apply(&completedParts[i])
 	}
 
-	_, err := s3Client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+	// S3 can take a while to assemble the parts, so this call gets its own
+	// budget while still being cancelled if the client goes away
+	ctx, cancel := context.WithTimeout(r.Context(), completeTimeout)
+	defer cancel()
+
+	if payload.Version == completePayloadV2 {
+		uploadAlgorithm, err := uploadChecksumAlgorithm(ctx, payload.Key, payload.UploadId)
+		switch {
+		case hasErrorCode(err, "NoSuchUpload"):
+			// Left for the complete below, as with verify
+		case err != nil:
+			log.Printf("Error checking multipart upload checksum algorithm: %v", err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to check upload checksum algorithm: %v", err), http.StatusInternalServerError)
+			}
+			return
+		case uploadAlgorithm == "":
+			errs.add("version", "needs an upload initiated with a checksumAlgorithm")
+		case uploadAlgorithm != checksumAlgorithm:
+			errs.add("parts", fmt.Sprintf("must have %s checksums, the upload's checksumAlgorithm", uploadAlgorithm))
+		}
+		if errs.respond(w) {
+			return
+		}
+	}
+
+	if payload.Verify {
+		mismatches, err := verifyParts(ctx, payload.Key, payload.UploadId, completedParts)
+		switch {
+		case hasErrorCode(err, "NoSuchUpload"):
+			// Left for the complete below, which tells a retry of a complete
+			// that succeeded from an upload that never existed
+		case err != nil:
+			log.Printf("Error verifying multipart upload parts: %v", err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to verify parts: %v", err), http.StatusInternalServerError)
+			}
+			return
+		case len(mismatches) > 0:
+			respondPartMismatches(w, mismatches)
+			return
+		}
+	}
+
+	// Only a replaced object can be cached by CloudFront
+	overwritten := invalidator.overwrites(ctx, payload.Key)
+
+	completedUpload, err := s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
 		Bucket:   aws.String(bucket),
 		Key:      aws.String(payload.Key),
 		UploadId: aws.String(payload.UploadId),
@@ -183,12 +983,96 @@ func handleCompleteMultipart(w http.ResponseWriter, r *http.Request) {
 			Parts: completedParts,
 		},
 	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Timed out completing multipart upload after %s", completeTimeout)
+		http.Error(w, "Timed out completing multipart upload", http.StatusGatewayTimeout)
+		return
+	}
+	// A retried complete finds the upload already gone, and its object
+	// already invalidated and charged by the complete that succeeded
+	var retried bool
+	if hasErrorCode(err, "NoSuchUpload") {
+		completed, headErr := completedAlready(ctx, payload.Key, len(payload.Parts))
+		switch {
+		case headErr != nil:
+			err = headErr
+		case !completed:
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		default:
+			log.Printf("Multipart upload %s was already completed", payload.UploadId)
+			retried, err = true, nil
+		}
+	}
 	if err != nil {
 		log.Printf("Error completing multipart upload: %v", err)
+		if respondThrottled(w, err) {
+			return
+		}
 		http.Error(w, fmt.Sprintf("Failed to complete multipart upload: %v", err), http.StatusInternalServerError)
 		return
 	}
+	initiateCache.evictUpload(payload.UploadId)
+	uploadedParts.evict(payload.UploadId)
+	if overwritten && !retried {
+		invalidator.invalidate(payload.Key)
+	}
+	objectHeads.invalidate(payload.Key)
+	if !retried {
+		quotas.recordCompleted(r.Context(), payload.Key)
+	}
+	// Versioned buckets report the version the parts became, which a retry
+	// no longer gets to see
+	var versionID string
+	if completedUpload != nil {
+		versionID = aws.ToString(completedUpload.VersionId)
+	}
+	if versionID != "" {
+		w.Header().Set("X-Version-Id", versionID)
+	}
+
+	if payload.Publish {
+		published, err := publishObject(ctx, payload.Key, replace)
+		if err != nil {
+			log.Printf("Error publishing %s: %v", payload.Key, err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Upload completed but publishing failed: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+		result := map[string]string{
+			"key":          payload.Key,
+			"publishedKey": published,
+		}
+		if versionID != "" {
+			result["versionId"] = versionID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
 	// Set the content type to JSON
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Upload completed"))
 }
+
+// completedAlready reports whether key holds a multipart object of the given
+// number of parts, as it does after a complete that succeeded but whose
+// response the client never saw. S3 suffixes a multipart object's ETag with
+// its part count, which is as close as S3 lets us get to telling it came
+// from this upload rather than from an unrelated write.
+func completedAlready(ctx context.Context, key string, parts int) (bool, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.HasSuffix(strings.Trim(aws.ToString(head.ETag), `"`), fmt.Sprintf("-%d", parts)), nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// setGlobal sets one of the package's configuration variables for the rest
+// of the test.
+func setGlobal[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// fakeS3 points s3Client, and a real presign client over it, at a server
+// answering S3 calls with h, with the rest of the configuration at what main
+// would default it to. A nil h answers every call with an empty 200.
+func fakeS3(t *testing.T, h http.HandlerFunc) *httptest.Server {
+	if h == nil {
+		h = func(http.ResponseWriter, *http.Request) {}
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("AK", "SK", ""),
+		// Each fake answer is seen once, rather than retried with backoff
+		Retryer: aws.NopRetryer{},
+	})
+	setGlobal(t, &bucket, "b")
+	setGlobal(t, &region, "us-east-1")
+	setGlobal(t, &s3Client, client)
+	setGlobal(t, &presignClient, presigner(s3.NewPresignClient(client)))
+	setGlobal(t, &features, allFeatures())
+	setGlobal(t, &maxParts, maxPartNumber)
+	setGlobal(t, &maxBatchParts, 100)
+	setGlobal(t, &batchPresignConcurrency, 4)
+	setGlobal(t, &completeTimeout, time.Minute)
+	setGlobal(t, &maxTranscodeSourceSize, 25<<20)
+	setGlobal(t, &restoreTier, "Standard")
+	setGlobal(t, &restoreDays, 7)
+	setGlobal(t, &initiateCache, newIdempotencyCache(time.Hour))
+	setGlobal(t, &uploadedParts, newPartsCache(time.Minute))
+	return srv
+}
+
+// serve sends a request through routes().
+func serve(t *testing.T, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+}
+
+// errorFields returns the fields of a validationErrors response.
+func errorFields(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+	var resp struct {
+		Errors []fieldError `json:"errors"`
+	}
+	decodeJSON(t, rec, &resp)
+	fields := make([]string, len(resp.Errors))
+	for i, e := range resp.Errors {
+		fields[i] = e.Field
+	}
+	return fields
+}
+
+func TestGenerate(t *testing.T) {
+	fakeS3(t, nil)
+	p := useFakePresigner(t)
+
+	rec := serve(t, http.MethodGet, "/generate?filename=photo.jpg&contentType=image/jpeg", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	if len(p.puts) != 1 {
+		t.Fatalf("presigned %d PUTs, want 1", len(p.puts))
+	}
+	put := p.puts[0]
+	if got, want := aws.ToString(put.Key), keyPrefix+"photo.jpg"; got != want {
+		t.Errorf("Key = %q, want %q", got, want)
+	}
+	if got := aws.ToString(put.ContentType); got != "image/jpeg" {
+		t.Errorf("ContentType = %q, want image/jpeg", got)
+	}
+	if got := rec.Header().Get("X-Object-Key"); got != keyPrefix+"photo.jpg" {
+		t.Errorf("X-Object-Key = %q", got)
+	}
+	if got := rec.Header().Get("X-Content-Type"); got != "image/jpeg" {
+		t.Errorf("X-Content-Type = %q", got)
+	}
+	if err := validatePresignedURL(rec.Body.String(), keyPrefix+"photo.jpg"); err != nil {
+		t.Errorf("body %q: %v", rec.Body, err)
+	}
+}
+
+func TestGenerateValidation(t *testing.T) {
+	fakeS3(t, nil)
+	useFakePresigner(t)
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"missing filename", "", http.StatusBadRequest},
+		{"invalid contentType", "filename=a.txt&contentType=not/a/type", http.StatusBadRequest},
+		{"invalid overwrite", "filename=a.txt&overwrite=maybe", http.StatusBadRequest},
+		{"exactSize with maxSize", "filename=a.txt&exactSize=10&maxSize=20", http.StatusBadRequest},
+		{"invalid exactSize", "filename=a.txt&exactSize=-1", http.StatusBadRequest},
+		{"invalid expires", "filename=a.txt&expires=soon", http.StatusBadRequest},
+		{"invalid meta", "filename=a.txt&meta=novalue", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, http.MethodGet, "/generate?"+tt.query, "")
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestInitiateMultipart(t *testing.T) {
+	var created *http.Request
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		created = r
+		w.Write([]byte(`<InitiateMultipartUploadResult><Bucket>b</Bucket><Key>` + keyPrefix + `big.bin</Key><UploadId>U1</UploadId></InitiateMultipartUploadResult>`))
+	})
+
+	rec := serve(t, http.MethodPost, "/multipart/initiate?key=big.bin", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	var resp map[string]string
+	decodeJSON(t, rec, &resp)
+	if resp["uploadId"] != "U1" || resp["key"] != keyPrefix+"big.bin" {
+		t.Errorf("response = %v", resp)
+	}
+	if created == nil || !created.URL.Query().Has("uploads") {
+		t.Fatalf("CreateMultipartUpload was not called")
+	}
+	if want := "/b/" + keyPrefix + "big.bin"; created.URL.Path != want {
+		t.Errorf("created %s, want %s", created.URL.Path, want)
+	}
+
+	rec = serve(t, http.MethodPost, "/multipart/initiate", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("without key: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestPresignPartValidation(t *testing.T) {
+	fakeS3(t, nil)
+	useFakePresigner(t)
+	tests := []struct {
+		name   string
+		query  string
+		fields []string
+	}{
+		{"nothing", "", []string{"filename", "uploadId", "partNumber"}},
+		{"partNumber zero", "filename=a&uploadId=U&partNumber=0", []string{"partNumber"}},
+		{"partNumber not a number", "filename=a&uploadId=U&partNumber=x", []string{"partNumber"}},
+		{"partNumber over the limit", "filename=a&uploadId=U&partNumber=10001", []string{"partNumber"}},
+		{"checkExisting not a boolean", "filename=a&uploadId=U&partNumber=1&checkExisting=sometimes", []string{"checkExisting"}},
+		{"invalid expires", "filename=a&uploadId=U&partNumber=1&expires=-5", []string{"expires"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, http.MethodGet, "/multipart/presigned?"+tt.query, "")
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400; body %q", rec.Code, rec.Body)
+			}
+			if got := errorFields(t, rec); !slices.Equal(got, tt.fields) {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestCompleteMultipart(t *testing.T) {
+	var completed *http.Request
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		completed = r
+		w.Write([]byte(`<CompleteMultipartUploadResult/>`))
+	})
+
+	body := `{"key":"` + keyPrefix + `big.bin","uploadId":"U1","parts":[{"eTag":"\"e1\"","partNumber":1},{"eTag":"\"e2\"","partNumber":2}]}`
+	rec := serve(t, http.MethodPost, "/multipart/complete", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	if completed == nil || completed.URL.Query().Get("uploadId") != "U1" {
+		t.Fatalf("CompleteMultipartUpload was not called for U1")
+	}
+}
+
+func TestCompleteMultipartValidation(t *testing.T) {
+	fakeS3(t, nil)
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"invalid JSON", `{`, []string{"body"}},
+		{"empty", `{}`, []string{"key", "uploadId", "parts"}},
+		{"part without eTag", `{"key":"k","uploadId":"U","parts":[{"partNumber":1}]}`, []string{"parts[0].eTag"}},
+		{"part number out of range", `{"key":"k","uploadId":"U","parts":[{"eTag":"e","partNumber":0}]}`, []string{"parts[0].partNumber"}},
+		{"unknown version", `{"version":3,"key":"k","uploadId":"U","parts":[{"eTag":"e","partNumber":1}]}`, []string{"version"}},
+		{"metadataDirective without publish", `{"key":"k","uploadId":"U","parts":[{"eTag":"e","partNumber":1}],"metadataDirective":"COPY"}`, []string{"metadataDirective"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, http.MethodPost, "/multipart/complete", tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400; body %q", rec.Code, rec.Body)
+			}
+			if got := errorFields(t, rec); !slices.Equal(got, tt.fields) {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestPresignPartBatchValidation(t *testing.T) {
+	fakeS3(t, nil)
+	useFakePresigner(t)
+	tests := []struct {
+		name   string
+		query  url.Values
+		fields []string
+	}{
+		{"nothing", url.Values{}, []string{"key", "uploadId", "count"}},
+		{"count zero", url.Values{"key": {keyPrefix + "k"}, "uploadId": {"U"}, "count": {"0"}}, []string{"count"}},
+		{"count over MAX_BATCH_PARTS", url.Values{"key": {keyPrefix + "k"}, "uploadId": {"U"}, "count": {"101"}}, []string{"count"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, http.MethodGet, "/multipart/presigned/batch?"+tt.query.Encode(), "")
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400; body %q", rec.Code, rec.Body)
+			}
+			if got := errorFields(t, rec); !slices.Equal(got, tt.fields) {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestCompleteMultipartTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		delay  time.Duration
+		status int
+	}{
+		{"within COMPLETE_TIMEOUT", 0, http.StatusOK},
+		{"past COMPLETE_TIMEOUT", 300 * time.Millisecond, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+					return
+				}
+				w.Write([]byte(`<CompleteMultipartUploadResult/>`))
+			})
+			setGlobal(t, &completeTimeout, 50*time.Millisecond)
+
+			body := `{"key":"` + keyPrefix + `big.bin","uploadId":"U1","parts":[{"eTag":"e1","partNumber":1}]}`
+			rec := serve(t, http.MethodPost, "/multipart/complete", body)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRouteMethods(t *testing.T) {
+	fakeS3(t, nil)
+	tests := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodPost, "/generate", "GET, HEAD"},
+		{http.MethodDelete, "/download", "GET, HEAD"},
+		{http.MethodPut, "/multipart/initiate", "GET, HEAD, POST"},
+		{http.MethodGet, "/multipart/complete", "POST"},
+		{http.MethodGet, "/confirm", "POST"},
+		{http.MethodGet, "/admin/maintenance", "POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := serve(t, tt.method, tt.path, "")
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("status = %d, want 405", rec.Code)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+// A complete retried after it succeeded finds the upload gone; the object
+// then decides whether it was this upload that completed.
+func TestCompleteMultipartRetried(t *testing.T) {
+	tests := []struct {
+		name       string
+		headStatus int
+		eTag       string
+		status     int
+	}{
+		{"object with the same part count", http.StatusOK, `"abc-2"`, http.StatusOK},
+		{"object with another part count", http.StatusOK, `"abc-3"`, http.StatusNotFound},
+		{"object from a single PUT", http.StatusOK, `"abc"`, http.StatusNotFound},
+		{"no object", http.StatusNotFound, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.Header().Set("ETag", tt.eTag)
+					w.WriteHeader(tt.headStatus)
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`<Error><Code>NoSuchUpload</Code><Message>The specified upload does not exist.</Message></Error>`))
+			})
+
+			body := `{"key":"` + keyPrefix + `big.bin","uploadId":"U1","parts":[{"eTag":"e1","partNumber":1},{"eTag":"e2","partNumber":2}]}`
+			rec := serve(t, http.MethodPost, "/multipart/complete", body)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+// The complete that succeeded has charged the quota and invalidated the
+// object; its retry must do neither again.
+func TestCompleteMultipartRetriedChargesOnce(t *testing.T) {
+	var completed bool
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead && !completed:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodHead:
+			w.Header().Set("ETag", `"abc-2"`)
+			w.Header().Set("Content-Length", "100")
+		case !completed:
+			completed = true
+			w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"abc-2"</ETag></CompleteMultipartUploadResult>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>NoSuchUpload</Code><Message>The specified upload does not exist.</Message></Error>`))
+		}
+	})
+	limits, err := newQuotaLimits(map[string]int64{"default": 1000}, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setGlobal(t, &quotas, limits)
+	setGlobal(t, &userTokens, map[string]string{"tok": "alice"})
+	queued := queuedInvalidations(t)
+
+	body := `{"key":"` + keyPrefix + `big.bin","uploadId":"U1","parts":[{"eTag":"e1","partNumber":1},{"eTag":"e2","partNumber":2}]}`
+	for i := range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/multipart/complete", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer tok")
+		rec := httptest.NewRecorder()
+		routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("complete %d: status = %d, body %q", i+1, rec.Code, rec.Body)
+		}
+	}
+	if used := limits.store.usage("alice").Used; used != 100 {
+		t.Errorf("quota used = %d, want 100, charged once", used)
+	}
+	if got := queued(); len(got) != 0 {
+		t.Errorf("invalidated %v, want nothing for a new key", got)
+	}
+}
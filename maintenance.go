@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// maintenanceRetryAfter is the Retry-After, in seconds, sent while in
+// maintenance mode.
+const maintenanceRetryAfter = "60"
+
+// maintenance, started from MAINTENANCE_MODE and switched with
+// POST /admin/maintenance, pauses new uploads and presigns without taking the
+// service down: the paused endpoints answer 503 and /readyz reports not ready
+// so traffic drains, while /healthz stays healthy so the pod isn't restarted.
+// /multipart/complete is left open so uploads whose parts are all in can
+// still finish.
+var maintenance atomic.Bool
+
+// setMaintenance switches maintenance mode, logging only actual changes.
+func setMaintenance(enabled bool) {
+	if maintenance.Swap(enabled) == enabled {
+		return
+	}
+	if enabled {
+		log.Println("Entering maintenance mode: uploads and presigns are paused")
+	} else {
+		log.Println("Leaving maintenance mode")
+	}
+}
+
+// pauseInMaintenance answers 503 in place of next while in maintenance mode.
+func pauseInMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maintenance.Load() {
+			w.Header().Set("Retry-After", maintenanceRetryAfter)
+			http.Error(w, "Service is in maintenance, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleMaintenance switches maintenance mode on or off with
+// {"enabled": true|false} and reports the resulting state.
+func handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if payload.Enabled == nil {
+		http.Error(w, "Missing required field (enabled)", http.StatusBadRequest)
+		return
+	}
+	setMaintenance(*payload.Enabled)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{
+		"enabled": maintenance.Load(),
+	})
+}
+
+// handleHealth is the liveness probe: it answers 200 whenever the process is
+// serving, whatever the state of S3 or maintenance mode.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok")
+}
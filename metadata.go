@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// defaultMetadata is merged into the metadata of every upload.
+var defaultMetadata map[string]string
+
+var metadataKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// normalizeMetadata lowercases and validates the configured default metadata.
+func normalizeMetadata(parsed map[string]string) (map[string]string, error) {
+	if len(parsed) == 0 {
+		return nil, nil
+	}
+
+	metadata := make(map[string]string, len(parsed))
+	for k, v := range parsed {
+		k = strings.ToLower(k)
+		if err := validateMetadata(k, v); err != nil {
+			return nil, err
+		}
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+// uploadMetadata merges defaultMetadata with the request's repeated
+// meta=name:value parameters, the request winning on conflicts.
+//
+// For presigned PUTs the metadata is part of the signature, so the client must
+// send every entry as an x-amz-meta-<name> header with the same value.
+// /generate returns the merged entries, defaults included, as a JSON object
+// in X-Object-Metadata for the client to send. For
+// multipart uploads it is attached when the upload is created and the client
+// sends nothing extra.
+func uploadMetadata(query url.Values) (map[string]string, error) {
+	params := query["meta"]
+	if len(defaultMetadata) == 0 && len(params) == 0 {
+		return nil, nil
+	}
+
+	metadata := make(map[string]string, len(defaultMetadata)+len(params))
+	for k, v := range defaultMetadata {
+		metadata[k] = v
+	}
+	for _, param := range params {
+		k, v, ok := strings.Cut(param, ":")
+		if !ok {
+			return nil, fmt.Errorf("meta must be in name:value form")
+		}
+		k = strings.ToLower(k)
+		if err := validateMetadata(k, v); err != nil {
+			return nil, err
+		}
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+// ownerPattern bounds the owner recorded on multipart uploads to something
+// safe to use as an identifier in reports and quota tracking.
+var ownerPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,128}$`)
+
+// withOwner records owner under the "owner" metadata key, overriding any
+// meta=owner:... parameter so the dedicated parameter is authoritative.
+func withOwner(metadata map[string]string, owner string) (map[string]string, error) {
+	if owner == "" {
+		return metadata, nil
+	}
+	if !ownerPattern.MatchString(owner) {
+		return nil, fmt.Errorf("owner must be 1-128 letters, digits or ._@-")
+	}
+	if metadata == nil {
+		metadata = make(map[string]string, 1)
+	}
+	metadata["owner"] = owner
+	return metadata, nil
+}
+
+func validateMetadata(k, v string) error {
+	if !metadataKeyPattern.MatchString(k) {
+		return fmt.Errorf("invalid metadata name %q", k)
+	}
+	for _, c := range v {
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf("metadata value for %q must be printable ASCII", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// uploadSizeBuckets run from thumbnails to camera RAW files and video, with
+// the 5 MiB multipart minimum part size as one of the bounds.
+var uploadSizeBuckets = []float64{10 << 10, 50 << 10, 100 << 10, 250 << 10, 500 << 10, 1 << 20, 2 << 20, 5 << 20, 10 << 20, 25 << 20, 50 << 20, 100 << 20, 500 << 20, 1 << 30, 5 << 30}
+
+var (
+	headCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "s3image_head_cache_hits_total",
+		Help: "HeadObject lookups answered from the in-memory cache.",
+	})
+	headCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "s3image_head_cache_misses_total",
+		Help: "HeadObject lookups that had to call S3.",
+	})
+	breakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "s3image_s3_breaker_state",
+		Help: "State of the S3 circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+	breakerRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "s3image_s3_breaker_rejected_total",
+		Help: "S3 requests failed fast by the circuit breaker.",
+	})
+	overloadRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "s3image_requests_rejected_total",
+		Help: "HTTP requests turned away because MAX_CONCURRENT_REQUESTS were in flight.",
+	})
+	panics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "s3image_handler_panics_total",
+		Help: "Panics recovered from HTTP handlers.",
+	})
+	declaredUploadSizes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "s3image_upload_declared_size_bytes",
+		Help:    "Upload sizes declared up front, by /generate's size or maxSize or /upload's Content-Length.",
+		Buckets: uploadSizeBuckets,
+	})
+	transferredUploadSizes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "s3image_upload_transferred_size_bytes",
+		Help:    "Bytes actually received by successful /upload requests.",
+		Buckets: uploadSizeBuckets,
+	})
+)
+
+// registerMetrics registers the collectors with the default registry. One
+// that fails to register keeps counting and only goes unexported, so requests
+// are unaffected either way.
+func registerMetrics() error {
+	var errs []error
+	for _, c := range []prometheus.Collector{headCacheHits, headCacheMisses, breakerState, breakerRejected, overloadRejected, panics, declaredUploadSizes, transferredUploadSizes} {
+		if err := prometheus.Register(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// metricsHandler serves whatever the registry can gather, logging collectors
+// that fail instead of failing the whole scrape.
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		ErrorLog:      log.Default(),
+		ErrorHandling: promhttp.ContinueOnError,
+	}))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// useRegistry swaps the default registry for an empty one for the rest of the
+// test.
+func useRegistry(t *testing.T) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	setGlobal(t, &prometheus.DefaultRegisterer, prometheus.Registerer(reg))
+	setGlobal(t, &prometheus.DefaultGatherer, prometheus.Gatherer(reg))
+	return reg
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	useRegistry(t)
+	if err := registerMetrics(); err != nil {
+		t.Fatalf("registerMetrics = %v", err)
+	}
+
+	// Registering again collides with every collector, but each still counts
+	err := registerMetrics()
+	var already prometheus.AlreadyRegisteredError
+	if !errors.As(err, &already) {
+		t.Fatalf("registerMetrics again = %v, want AlreadyRegisteredError", err)
+	}
+
+	// One collector taken by something else leaves the rest registered
+	reg := useRegistry(t)
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "s3image_handler_panics_total", Help: "Not ours."}))
+	if err := registerMetrics(); err == nil {
+		t.Fatal("registerMetrics with a conflicting collector succeeded, want an error")
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, f := range families {
+		found = found || f.GetName() == "s3image_head_cache_hits_total"
+	}
+	if !found {
+		t.Error("s3image_head_cache_hits_total was not registered beside the conflict")
+	}
+}
+
+// failingCollector fails every collection.
+type failingCollector struct{ desc *prometheus.Desc }
+
+func (c failingCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+
+func (c failingCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.NewInvalidMetric(c.desc, errors.New("broken"))
+}
+
+func TestMetricsHandlerContinuesOnError(t *testing.T) {
+	reg := useRegistry(t)
+	// The failing collector is logged on every scrape
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+	reg.MustRegister(failingCollector{prometheus.NewDesc("s3image_broken", "Always fails.", nil, nil)})
+	if err := registerMetrics(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "s3image_handler_panics_total") {
+		t.Errorf("the scrape is missing the working collectors:\n%s", rec.Body)
+	}
+}
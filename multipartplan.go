@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// S3's bounds on part sizes. Only the last part of an upload may be smaller
+// than minPartSize.
+const (
+	minPartSize = 5 << 20
+	maxPartSize = 5 << 30
+)
+
+// handlePlanMultipart tells a client how to split an upload of the given
+// size: the smallest whole-MiB part size of at least minPartSize that fits
+// within maxParts parts. Parts smaller than that make uploads slower and
+// cost more requests without helping anyone.
+func handlePlanMultipart(w http.ResponseWriter, r *http.Request) {
+	sizeStr := r.URL.Query().Get("size")
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+
+	var errs validationErrors
+	switch {
+	case sizeStr == "":
+		errs.add("size", "is required")
+	case err != nil || size < 1:
+		errs.add("size", "must be a positive integer")
+	case size > int64(maxParts)*maxPartSize:
+		errs.add("size", fmt.Sprintf("must not exceed %d", int64(maxParts)*maxPartSize))
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	partSize := max(minPartSize, (size+int64(maxParts)-1)/int64(maxParts))
+	partSize = (partSize + 1<<20 - 1) &^ (1<<20 - 1)
+	partCount := (size + partSize - 1) / partSize
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"size":         size,
+		"partSize":     partSize,
+		"partCount":    partCount,
+		"lastPartSize": size - (partCount-1)*partSize,
+		"minPartSize":  minPartSize,
+		"maxParts":     maxParts,
+	})
+}
+
+// declaredPartSize reads the optional partSize parameter of a part presign.
+// The size is signed into the URL so S3 rejects a body of any other length.
+// It must be at least minPartSize unless lastPart=true, since S3 only checks
+// that when the upload is completed and by then the whole upload is wasted.
+func declaredPartSize(query url.Values, errs *validationErrors) *int64 {
+	v := query.Get("partSize")
+	if v == "" {
+		return nil
+	}
+	lastPart := false
+	if s := query.Get("lastPart"); s != "" {
+		var err error
+		if lastPart, err = strconv.ParseBool(s); err != nil {
+			errs.add("lastPart", "must be a boolean")
+			return nil
+		}
+	}
+	size, err := strconv.ParseInt(v, 10, 64)
+	switch {
+	case err != nil || size < 1:
+		errs.add("partSize", "must be a positive integer")
+	case size > maxPartSize:
+		errs.add("partSize", fmt.Sprintf("must not exceed %d", int64(maxPartSize)))
+	case size < minPartSize && !lastPart:
+		errs.add("partSize", fmt.Sprintf("must be at least %d except for the last part", minPartSize))
+	default:
+		return &size
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// nonceStore gives /generate best-effort single-use semantics, enabled by
+// SINGLE_USE_NONCES. Each request carries a client-chosen nonce, and a nonce
+// whose object has been uploaded is refused from then on, so a leaked nonce
+// can't be used to mint fresh URLs. A nonce can be presigned again, for the
+// same key only, while nothing has been uploaded for it, which lets a client
+// that lost its URL ask again.
+//
+// This limits issuance only. S3 has no way to make a presigned URL
+// single-use, so a URL already handed out can still be replayed until it
+// expires. The store is also per instance and in memory, so replicas don't
+// share it and a restart forgets it.
+type nonceStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]nonceEntry
+}
+
+type nonceEntry struct {
+	key       string
+	used      bool
+	expiresAt time.Time
+}
+
+// nonces is nil unless SINGLE_USE_NONCES is set.
+var nonces *nonceStore
+
+var noncePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+var (
+	errNonceUsed     = errors.New("nonce has already been used")
+	errNonceOtherKey = errors.New("nonce was issued for a different key")
+)
+
+func newNonceStore(ttl time.Duration) *nonceStore {
+	return &nonceStore{
+		ttl:     ttl,
+		entries: make(map[string]nonceEntry),
+	}
+}
+
+// claim records nonce as issued for key. For a nonce issued before, it
+// checks whether the object has since been uploaded, and refuses the nonce
+// if so.
+func (s *nonceStore) claim(ctx context.Context, nonce, key string) error {
+	s.mu.Lock()
+	now := time.Now()
+	for n, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, n)
+		}
+	}
+	entry, seen := s.entries[nonce]
+	if !seen {
+		s.entries[nonce] = nonceEntry{key: key, expiresAt: now.Add(s.ttl)}
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !seen:
+		return nil
+	case entry.used:
+		return errNonceUsed
+	case entry.key != key:
+		return errNonceOtherKey
+	}
+
+	_, err := headObject(ctx, key)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.used = true
+	s.entries[nonce] = entry
+	return errNonceUsed
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// jsonNotFound answers requests that match no route with a JSON 404 in the
+// same shape as validation errors, instead of the mux's plaintext one.
+// Method mismatches still get the mux's 405 with its Allow header, and a 404
+// written by a matched handler is left alone.
+func jsonNotFound(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			w = &notFoundWriter{ResponseWriter: w, path: r.URL.Path}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// notFoundWriter replaces a 404 and its body with the JSON error.
+type notFoundWriter struct {
+	http.ResponseWriter
+	path     string
+	replaced bool
+}
+
+func (w *notFoundWriter) WriteHeader(status int) {
+	if status != http.StatusNotFound {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.replaced = true
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(status)
+	json.NewEncoder(w.ResponseWriter).Encode(map[string]any{
+		"errors": validationErrors{{Field: "path", Message: "no such endpoint"}},
+		"path":   w.path,
+	})
+}
+
+func (w *notFoundWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
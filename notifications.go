@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// notificationTarget, from NOTIFICATION_TARGET_ARN, is the SNS topic, SQS
+// queue or Lambda function told about new uploads.
+var notificationTarget *arn.ARN
+
+// notificationID names the configuration we manage, so setting it up again
+// replaces ours and leaves any others on the bucket alone.
+const notificationID = "s3-image-object-created"
+
+func parseNotificationTarget(raw string) (*arn.ARN, error) {
+	parsed, err := arn.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Service {
+	case "sns", "sqs", "lambda":
+	default:
+		return nil, fmt.Errorf("ARN service must be sns, sqs or lambda, got %q", parsed.Service)
+	}
+	if parsed.Region == "" || parsed.AccountID == "" || parsed.Resource == "" {
+		return nil, fmt.Errorf("ARN must include a region, account ID and resource")
+	}
+	if parsed.Service == "lambda" && !strings.HasPrefix(parsed.Resource, "function:") {
+		return nil, fmt.Errorf("lambda ARN must reference a function, got resource %q", parsed.Resource)
+	}
+	return &parsed, nil
+}
+
+// handleSetupNotifications points the bucket's s3:ObjectCreated:* events
+// under keyPrefix at NOTIFICATION_TARGET_ARN. PutBucketNotificationConfiguration
+// replaces the whole configuration, so the current one is read first and
+// only our entry is swapped out. The target's own policy must already let
+// S3 publish to it, or S3 rejects the configuration.
+func handleSetupNotifications(w http.ResponseWriter, r *http.Request) {
+	if notificationTarget == nil {
+		http.Error(w, "NOTIFICATION_TARGET_ARN is not set", http.StatusBadRequest)
+		return
+	}
+
+	current, err := s3Client.GetBucketNotificationConfiguration(r.Context(), &s3.GetBucketNotificationConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		log.Printf("Error reading bucket notification configuration: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to read bucket notification configuration: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	conf := &types.NotificationConfiguration{
+		EventBridgeConfiguration: current.EventBridgeConfiguration,
+	}
+	for _, c := range current.TopicConfigurations {
+		if aws.ToString(c.Id) != notificationID {
+			conf.TopicConfigurations = append(conf.TopicConfigurations, c)
+		}
+	}
+	for _, c := range current.QueueConfigurations {
+		if aws.ToString(c.Id) != notificationID {
+			conf.QueueConfigurations = append(conf.QueueConfigurations, c)
+		}
+	}
+	for _, c := range current.LambdaFunctionConfigurations {
+		if aws.ToString(c.Id) != notificationID {
+			conf.LambdaFunctionConfigurations = append(conf.LambdaFunctionConfigurations, c)
+		}
+	}
+
+	target := notificationTarget.String()
+	events := []types.Event{"s3:ObjectCreated:*"}
+	filter := &types.NotificationConfigurationFilter{
+		Key: &types.S3KeyFilter{
+			FilterRules: []types.FilterRule{{
+				Name:  types.FilterRuleNamePrefix,
+				Value: aws.String(keyPrefix),
+			}},
+		},
+	}
+	switch notificationTarget.Service {
+	case "sns":
+		conf.TopicConfigurations = append(conf.TopicConfigurations, types.TopicConfiguration{
+			Id:       aws.String(notificationID),
+			TopicArn: aws.String(target),
+			Events:   events,
+			Filter:   filter,
+		})
+	case "sqs":
+		conf.QueueConfigurations = append(conf.QueueConfigurations, types.QueueConfiguration{
+			Id:       aws.String(notificationID),
+			QueueArn: aws.String(target),
+			Events:   events,
+			Filter:   filter,
+		})
+	case "lambda":
+		conf.LambdaFunctionConfigurations = append(conf.LambdaFunctionConfigurations, types.LambdaFunctionConfiguration{
+			Id:                aws.String(notificationID),
+			LambdaFunctionArn: aws.String(target),
+			Events:            events,
+			Filter:            filter,
+		})
+	}
+
+	_, err = s3Client.PutBucketNotificationConfiguration(r.Context(), &s3.PutBucketNotificationConfigurationInput{
+		Bucket:                    aws.String(bucket),
+		NotificationConfiguration: conf,
+	})
+	if err != nil {
+		log.Printf("Error applying bucket notification configuration: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to apply bucket notification configuration: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("Applied bucket notifications for %s to %s", keyPrefix, target)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"target": target,
+		"events": events,
+		"prefix": keyPrefix,
+	})
+}
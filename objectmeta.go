@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type objectMetadata struct {
+	Key                  string            `json:"key"`
+	ContentType          string            `json:"contentType"`
+	ContentLength        int64             `json:"contentLength"`
+	ETag                 string            `json:"eTag"`
+	LastModified         string            `json:"lastModified,omitempty"`
+	StorageClass         string            `json:"storageClass"`
+	ServerSideEncryption string            `json:"serverSideEncryption,omitempty"`
+	KMSKeyID             string            `json:"kmsKeyId,omitempty"`
+	BucketKeyEnabled     bool              `json:"bucketKeyEnabled"`
+	CacheControl         string            `json:"cacheControl,omitempty"`
+	ContentDisposition   string            `json:"contentDisposition,omitempty"`
+	ContentEncoding      string            `json:"contentEncoding,omitempty"`
+	VersionID            string            `json:"versionId,omitempty"`
+	ReplicationStatus    string            `json:"replicationStatus,omitempty"`
+	Metadata             map[string]string `json:"metadata"`
+}
+
+// handleMetadata returns everything HeadObject knows about an uploaded
+// object, including its x-amz-meta-* values keyed without the prefix.
+func handleMetadata(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	filename = userFilename(r, filename)
+
+	key := keyPrefix + filename
+	head, err := headObject(r.Context(), key)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading object metadata: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to read object metadata: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	meta := objectMetadata{
+		Key:                  key,
+		ContentType:          aws.ToString(head.ContentType),
+		ContentLength:        aws.ToInt64(head.ContentLength),
+		ETag:                 aws.ToString(head.ETag),
+		StorageClass:         string(head.StorageClass),
+		ServerSideEncryption: string(head.ServerSideEncryption),
+		KMSKeyID:             aws.ToString(head.SSEKMSKeyId),
+		BucketKeyEnabled:     aws.ToBool(head.BucketKeyEnabled),
+		CacheControl:         aws.ToString(head.CacheControl),
+		ContentDisposition:   aws.ToString(head.ContentDisposition),
+		ContentEncoding:      aws.ToString(head.ContentEncoding),
+		VersionID:            aws.ToString(head.VersionId),
+		ReplicationStatus:    replicationStatus(head.ReplicationStatus),
+		Metadata:             head.Metadata,
+	}
+	if head.LastModified != nil {
+		meta.LastModified = head.LastModified.UTC().Format(http.TimeFormat)
+	}
+	// S3 leaves the storage class out for STANDARD objects
+	if meta.StorageClass == "" {
+		meta.StorageClass = string(types.StorageClassStandard)
+	}
+	if meta.Metadata == nil {
+		meta.Metadata = map[string]string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// replicationStatus names an object's replication state, empty when the
+// bucket has no replication rule covering it. S3 can report completion as
+// either COMPLETE or COMPLETED, so both map to "completed". REPLICA marks
+// the copy in the destination bucket.
+func replicationStatus(status types.ReplicationStatus) string {
+	switch status {
+	case types.ReplicationStatusPending:
+		return "pending"
+	case types.ReplicationStatusComplete, types.ReplicationStatusCompleted:
+		return "completed"
+	case types.ReplicationStatusFailed:
+		return "failed"
+	case types.ReplicationStatusReplica:
+		return "replica"
+	default:
+		return strings.ToLower(string(status))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxBatchParts caps how many part URLs one /multipart/presigned/batch call
+// returns, and batchPresignConcurrency how many are signed at once.
+var (
+	maxBatchParts           int
+	batchPresignConcurrency int
+)
+
+type presignedPart struct {
+	PartNumber int    `json:"partNumber"`
+	URL        string `json:"url"`
+}
+
+// batchPartParams are the /multipart/presigned parameters a batch can take
+// once per part.
+var batchPartParams = []string{"partSize", "checksumCRC32", "checksumCRC32C", "checksumSHA1", "checksumSHA256"}
+
+// handlePresignPartBatch presigns count consecutive parts starting at start
+// (1 by default), saving clients a round trip per part. partSize and the
+// checksums are signed into each URL as by /multipart/presigned, repeated
+// once per part in part order; a single partSize applies to every part, and
+// lastPart marks the batch's final part as the upload's last.
+func handlePresignPartBatch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key := query.Get("key")
+	uploadId := query.Get("uploadId")
+
+	var errs validationErrors
+	switch {
+	case key == "":
+		errs.add("key", "is required")
+	case !strings.HasPrefix(key, userKeyPrefix(r)):
+		errs.add("key", fmt.Sprintf("must be under %s", userKeyPrefix(r)))
+	}
+	if uploadId == "" {
+		errs.add("uploadId", "is required")
+	}
+
+	start := 1
+	if v := query.Get("start"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxParts {
+			errs.add("start", fmt.Sprintf("must be between 1 and %d", maxParts))
+		} else {
+			start = n
+		}
+	}
+	countStr := query.Get("count")
+	count, err := strconv.Atoi(countStr)
+	switch {
+	case countStr == "":
+		errs.add("count", "is required")
+	case err != nil || count < 1:
+		errs.add("count", "must be a positive integer")
+	case count > maxBatchParts:
+		errs.add("count", fmt.Sprintf("must not exceed %d", maxBatchParts))
+	case start+count-1 > maxParts:
+		errs.add("count", fmt.Sprintf("part numbers must not exceed %d", maxParts))
+	}
+	expiry, err := requestedExpiry(query, uploadExpiry)
+	if err != nil {
+		errs.add("expires", err.Error())
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	for _, name := range batchPartParams {
+		if n := len(query[name]); n > 1 && n != count || n == 1 && count > 1 && name != "partSize" {
+			errs.add(name, fmt.Sprintf("must be given once per part, %d times", count))
+		}
+	}
+	if errs.respond(w) {
+		return
+	}
+	inputs := make([]*s3.UploadPartInput, count)
+	seen := make(map[fieldError]bool)
+	for i := range inputs {
+		partNumber := start + i
+		partQuery := batchPartQuery(query, i, count)
+		var partErrs validationErrors
+		partSize := declaredPartSize(partQuery, &partErrs)
+		checksums := partChecksumsFrom(partQuery)
+		if _, err := checksums.checksumAlgorithm(); err != nil {
+			partErrs.add("checksum", err.Error())
+		}
+		// A partSize shared by every part is only reported once
+		for _, e := range partErrs {
+			if !seen[e] {
+				seen[e] = true
+				errs.add(e.Field, fmt.Sprintf("part %d: %s", partNumber, e.Message))
+			}
+		}
+		inputs[i] = &s3.UploadPartInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(key),
+			PartNumber:    aws.Int32(int32(partNumber)),
+			UploadId:      aws.String(uploadId),
+			ContentLength: partSize,
+		}
+		checksums.applyUpload(inputs[i])
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	parts := make([]presignedPart, count)
+	partErrs := make([]error, count)
+	sem := make(chan struct{}, batchPresignConcurrency)
+	expires := presignExpires(r.Context(), expiry)
+	var wg sync.WaitGroup
+	for i := range parts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			partNumber := start + i
+			req, err := presignClient.PresignUploadPart(r.Context(), inputs[i], expires)
+			if err == nil {
+				err = validatePresignedURL(req.URL, key)
+			}
+			if err != nil {
+				partErrs[i] = fmt.Errorf("part %d: %w", partNumber, err)
+				return
+			}
+			parts[i] = presignedPart{PartNumber: partNumber, URL: req.URL}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range partErrs {
+		if err != nil {
+			log.Printf("Error generating presigned part URLs: %v", err)
+			http.Error(w, "Failed to generate presigned part URLs", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	for _, part := range parts {
+		auditPresign(r, "UploadPart", key, part.URL)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(parts)
+}
+
+// batchPartQuery picks the values of part i of count out of a batch's query,
+// as /multipart/presigned would be given them.
+func batchPartQuery(query url.Values, i, count int) url.Values {
+	part := url.Values{}
+	for _, name := range batchPartParams {
+		switch values := query[name]; len(values) {
+		case 0:
+		case count:
+			part.Set(name, values[i])
+		default:
+			part.Set(name, values[0])
+		}
+	}
+	if i == count-1 && query.Has("lastPart") {
+		part.Set("lastPart", query.Get("lastPart"))
+	}
+	return part
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Versions of the /multipart/complete payload. Version 1, assumed when
+// version is absent, is parts of eTag and partNumber. Version 2 adds a
+// checksum to every part, which S3 checks against the one it computed for
+// the part on upload. S3 only takes these for uploads created with the same
+// checksum algorithm, so version 2 is refused for uploads /multipart/initiate
+// wasn't given a checksumAlgorithm, or was given a different one.
+const (
+	completePayloadV1 = 1
+	completePayloadV2 = 2
+)
+
+// parseChecksumAlgorithm parses the checksumAlgorithm /multipart/initiate
+// creates the upload with, one of those partChecksums can carry.
+func parseChecksumAlgorithm(raw string) (types.ChecksumAlgorithm, error) {
+	algorithm := types.ChecksumAlgorithm(strings.ToUpper(raw))
+	switch algorithm {
+	case types.ChecksumAlgorithmCrc32, types.ChecksumAlgorithmCrc32c,
+		types.ChecksumAlgorithmSha1, types.ChecksumAlgorithmSha256:
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("checksumAlgorithm must be CRC32, CRC32C, SHA1 or SHA256")
+}
+
+// uploadChecksumAlgorithm returns the checksum algorithm the upload was
+// created with, or "" when it has none.
+func uploadChecksumAlgorithm(ctx context.Context, key, uploadId string) (types.ChecksumAlgorithm, error) {
+	resp, err := s3Client.ListParts(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+		MaxParts: aws.Int32(1),
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.ChecksumAlgorithm, nil
+}
+
+// partChecksums are the base64 checksums of a version 2 part, as S3 returns
+// them from UploadPart. Exactly one is set. /multipart/presigned takes the same
+// names as query parameters and signs the checksum into the part URL, so S3
+// refuses a part whose body doesn't match it.
+type partChecksums struct {
+	ChecksumCRC32  string `json:"checksumCRC32"`
+	ChecksumCRC32C string `json:"checksumCRC32C"`
+	ChecksumSHA1   string `json:"checksumSHA1"`
+	ChecksumSHA256 string `json:"checksumSHA256"`
+}
+
+// checksumAlgorithm returns the algorithm of the one checksum set, or "" when
+// there is none.
+func (c partChecksums) checksumAlgorithm() (types.ChecksumAlgorithm, error) {
+	var algorithm types.ChecksumAlgorithm
+	for _, checksum := range []struct {
+		name      string
+		value     string
+		algorithm types.ChecksumAlgorithm
+		size      int
+	}{
+		{"checksumCRC32", c.ChecksumCRC32, types.ChecksumAlgorithmCrc32, 4},
+		{"checksumCRC32C", c.ChecksumCRC32C, types.ChecksumAlgorithmCrc32c, 4},
+		{"checksumSHA1", c.ChecksumSHA1, types.ChecksumAlgorithmSha1, 20},
+		{"checksumSHA256", c.ChecksumSHA256, types.ChecksumAlgorithmSha256, 32},
+	} {
+		if checksum.value == "" {
+			continue
+		}
+		if algorithm != "" {
+			return "", fmt.Errorf("must have only one checksum")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(checksum.value)
+		if err != nil || len(decoded) != checksum.size {
+			return "", fmt.Errorf("%s must be a base64 %s checksum", checksum.name, checksum.algorithm)
+		}
+		algorithm = checksum.algorithm
+	}
+	return algorithm, nil
+}
+
+func partChecksumsFrom(query url.Values) partChecksums {
+	return partChecksums{
+		ChecksumCRC32:  query.Get("checksumCRC32"),
+		ChecksumCRC32C: query.Get("checksumCRC32C"),
+		ChecksumSHA1:   query.Get("checksumSHA1"),
+		ChecksumSHA256: query.Get("checksumSHA256"),
+	}
+}
+
+// apply copies the checksums onto part.
+func (c partChecksums) apply(part *types.CompletedPart) {
+	c.set(&part.ChecksumCRC32, &part.ChecksumCRC32C, &part.ChecksumSHA1, &part.ChecksumSHA256)
+}
+
+// applyUpload copies the checksums onto a part's upload, so they are signed.
+func (c partChecksums) applyUpload(input *s3.UploadPartInput) {
+	c.set(&input.ChecksumCRC32, &input.ChecksumCRC32C, &input.ChecksumSHA1, &input.ChecksumSHA256)
+}
+
+func (c partChecksums) set(crc32, crc32c, sha1, sha256 **string) {
+	for _, checksum := range []struct {
+		value string
+		field **string
+	}{
+		{c.ChecksumCRC32, crc32},
+		{c.ChecksumCRC32C, crc32c},
+		{c.ChecksumSHA1, sha1},
+		{c.ChecksumSHA256, sha256},
+	} {
+		if checksum.value != "" {
+			*checksum.field = aws.String(checksum.value)
+		}
+	}
+}
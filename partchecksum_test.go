@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var (
+	crc32Checksum  = base64.StdEncoding.EncodeToString(make([]byte, 4))
+	sha256Checksum = base64.StdEncoding.EncodeToString(make([]byte, 32))
+)
+
+func TestParseChecksumAlgorithm(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    types.ChecksumAlgorithm
+		wantErr bool
+	}{
+		{"CRC32", types.ChecksumAlgorithmCrc32, false},
+		{"crc32c", types.ChecksumAlgorithmCrc32c, false},
+		{"Sha1", types.ChecksumAlgorithmSha1, false},
+		{"SHA256", types.ChecksumAlgorithmSha256, false},
+		{"CRC64NVME", "", true},
+		{"MD5", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseChecksumAlgorithm(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseChecksumAlgorithm(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseChecksumAlgorithm(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestPartChecksumsAlgorithm(t *testing.T) {
+	tests := []struct {
+		name      string
+		checksums partChecksums
+		want      types.ChecksumAlgorithm
+		wantErr   bool
+	}{
+		{"none", partChecksums{}, "", false},
+		{"CRC32", partChecksums{ChecksumCRC32: crc32Checksum}, types.ChecksumAlgorithmCrc32, false},
+		{"SHA256", partChecksums{ChecksumSHA256: sha256Checksum}, types.ChecksumAlgorithmSha256, false},
+		{"two checksums", partChecksums{ChecksumCRC32: crc32Checksum, ChecksumCRC32C: crc32Checksum}, "", true},
+		{"wrong length", partChecksums{ChecksumSHA1: crc32Checksum}, "", true},
+		{"not base64", partChecksums{ChecksumCRC32: "????"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.checksums.checksumAlgorithm()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checksumAlgorithm error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("checksumAlgorithm = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitiateChecksumAlgorithm(t *testing.T) {
+	var created *http.Request
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		created = r
+		w.Write([]byte(`<InitiateMultipartUploadResult><Key>` + keyPrefix + `big.bin</Key><UploadId>U1</UploadId></InitiateMultipartUploadResult>`))
+	})
+
+	rec := serve(t, http.MethodPost, "/multipart/initiate?key=big.bin&checksumAlgorithm=crc32", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	if got := created.Header.Get("X-Amz-Checksum-Algorithm"); got != "CRC32" {
+		t.Errorf("x-amz-checksum-algorithm = %q, want CRC32", got)
+	}
+	var resp map[string]string
+	decodeJSON(t, rec, &resp)
+	if resp["checksumAlgorithm"] != "CRC32" {
+		t.Errorf("checksumAlgorithm = %q, want CRC32", resp["checksumAlgorithm"])
+	}
+
+	rec = serve(t, http.MethodPost, "/multipart/initiate?key=big.bin&checksumAlgorithm=md5", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid checksumAlgorithm: status = %d, want 400", rec.Code)
+	}
+}
+
+// The checksum is signed into the part URL, so S3 checks the body against it
+// without the client sending anything more.
+func TestPresignPartChecksum(t *testing.T) {
+	fakeS3(t, nil)
+	target := "/multipart/presigned?" + url.Values{
+		"filename":      {"big.bin"},
+		"uploadId":      {"U1"},
+		"partNumber":    {"1"},
+		"checksumCRC32": {crc32Checksum},
+	}.Encode()
+	rec := serve(t, http.MethodGet, target, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	var resp struct {
+		URL string `json:"url"`
+	}
+	decodeJSON(t, rec, &resp)
+	u, err := url.Parse(resp.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("X-Amz-Checksum-Crc32"); got != crc32Checksum {
+		t.Errorf("X-Amz-Checksum-Crc32 = %q, want %q", got, crc32Checksum)
+	}
+
+	for _, query := range []string{"checksumCRC32=" + url.QueryEscape(sha256Checksum), "checksumCRC32=" + crc32Checksum + "&checksumSHA256=" + url.QueryEscape(sha256Checksum)} {
+		rec := serve(t, http.MethodGet, "/multipart/presigned?filename=big.bin&uploadId=U1&partNumber=1&"+query, "")
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400; body %q", query, rec.Code, rec.Body)
+		}
+		if got := errorFields(t, rec); !slices.Equal(got, []string{"checksum"}) {
+			t.Errorf("%s: fields = %v, want [checksum]", query, got)
+		}
+	}
+}
+
+// A batch signs each part's own size and checksum, as /multipart/presigned
+// signs one part's.
+func TestPresignPartBatchPerPart(t *testing.T) {
+	otherCRC32 := base64.StdEncoding.EncodeToString([]byte{1, 2, 3, 4})
+	batch := "/multipart/presigned/batch?key=" + keyPrefix + "big.bin&uploadId=U1&start=3&count=2&"
+	tests := []struct {
+		name      string
+		query     string
+		sizes     []int64
+		checksums []string
+		fields    []string
+	}{
+		{"shared size", "partSize=5242880", []int64{5 << 20, 5 << 20}, []string{"", ""}, nil},
+		{"sizes per part", "partSize=5242880&partSize=100&lastPart=true", []int64{5 << 20, 100}, []string{"", ""}, nil},
+		{"checksums per part", "checksumCRC32=" + url.QueryEscape(crc32Checksum) + "&checksumCRC32=" + url.QueryEscape(otherCRC32), []int64{0, 0}, []string{crc32Checksum, otherCRC32}, nil},
+		{"one checksum for two parts", "checksumCRC32=" + url.QueryEscape(crc32Checksum), nil, nil, []string{"checksumCRC32"}},
+		{"too many sizes", "partSize=5242880&partSize=5242880&partSize=5242880", nil, nil, []string{"partSize"}},
+		{"shared size below the minimum", "partSize=100", nil, nil, []string{"partSize"}},
+		{"short part before the last", "partSize=100&partSize=100&lastPart=true", nil, nil, []string{"partSize"}},
+		{"bad checksum for one part", "checksumCRC32=" + url.QueryEscape(crc32Checksum) + "&checksumCRC32=" + url.QueryEscape(sha256Checksum), nil, nil, []string{"checksum"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeS3(t, nil)
+			p := useFakePresigner(t)
+			rec := serve(t, http.MethodGet, batch+tt.query, "")
+			if tt.fields != nil {
+				if rec.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want 400; body %q", rec.Code, rec.Body)
+				}
+				if got := errorFields(t, rec); !slices.Equal(got, tt.fields) {
+					t.Errorf("fields = %v, want %v", got, tt.fields)
+				}
+				return
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			parts := slices.SortedFunc(slices.Values(p.parts), func(a, b *s3.UploadPartInput) int {
+				return int(aws.ToInt32(a.PartNumber) - aws.ToInt32(b.PartNumber))
+			})
+			if len(parts) != 2 {
+				t.Fatalf("presigned %d parts, want 2", len(parts))
+			}
+			for i, part := range parts {
+				if got := aws.ToInt64(part.ContentLength); got != tt.sizes[i] {
+					t.Errorf("part %d: ContentLength = %d, want %d", aws.ToInt32(part.PartNumber), got, tt.sizes[i])
+				}
+				if got := aws.ToString(part.ChecksumCRC32); got != tt.checksums[i] {
+					t.Errorf("part %d: ChecksumCRC32 = %q, want %q", aws.ToInt32(part.PartNumber), got, tt.checksums[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompletePayloadVersions(t *testing.T) {
+	part := func(n int, checksum string) string {
+		return fmt.Sprintf(`{"eTag":"e%d","partNumber":%d%s}`, n, n, checksum)
+	}
+	crc32 := `,"checksumCRC32":"` + crc32Checksum + `"`
+	sha256 := `,"checksumSHA256":"` + sha256Checksum + `"`
+	tests := []struct {
+		name            string
+		version         string
+		parts           []string
+		uploadAlgorithm string
+		fields          []string
+	}{
+		{"v1", ``, []string{part(1, ""), part(2, "")}, "", nil},
+		{"explicit v1", `"version":1,`, []string{part(1, "")}, "", nil},
+		{"v1 with a checksum", ``, []string{part(1, crc32)}, "", []string{"parts[0]"}},
+		{"v2", `"version":2,`, []string{part(1, crc32), part(2, crc32)}, "CRC32", nil},
+		{"v2 without a checksum", `"version":2,`, []string{part(1, crc32), part(2, "")}, "CRC32", []string{"parts[1]"}},
+		{"v2 mixing algorithms", `"version":2,`, []string{part(1, crc32), part(2, sha256)}, "CRC32", []string{"parts[1]"}},
+		{"v2 invalid checksum", `"version":2,`, []string{part(1, `,"checksumCRC32":"AA=="`)}, "CRC32", []string{"parts[0]"}},
+		{"v2 for an upload without an algorithm", `"version":2,`, []string{part(1, crc32)}, "", []string{"version"}},
+		{"v2 with the upload's other algorithm", `"version":2,`, []string{part(1, sha256)}, "CRC32", []string{"parts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var completed string
+			fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					w.Write([]byte(`<ListPartsResult><ChecksumAlgorithm>` + tt.uploadAlgorithm + `</ChecksumAlgorithm></ListPartsResult>`))
+				case http.MethodPost:
+					body, _ := io.ReadAll(r.Body)
+					completed = string(body)
+					w.Write([]byte(`<CompleteMultipartUploadResult/>`))
+				}
+			})
+			body := `{` + tt.version + `"key":"` + keyPrefix + `big.bin","uploadId":"U1","parts":[` + strings.Join(tt.parts, ",") + `]}`
+			rec := serve(t, http.MethodPost, "/multipart/complete", body)
+			if tt.fields != nil {
+				if rec.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want 400; body %q", rec.Code, rec.Body)
+				}
+				if got := errorFields(t, rec); !slices.Equal(got, tt.fields) {
+					t.Errorf("fields = %v, want %v", got, tt.fields)
+				}
+				return
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			hasChecksum := strings.Contains(completed, "<ChecksumCRC32>"+crc32Checksum+"</ChecksumCRC32>")
+			if wantChecksum := tt.uploadAlgorithm != ""; hasChecksum != wantChecksum {
+				t.Errorf("completed with %q, want checksums %v", completed, wantChecksum)
+			}
+		})
+	}
+}
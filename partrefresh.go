@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// handleRefreshPartURL is the recovery path for a part URL that expired
+// before a slow client got to use it: it signs a fresh URL for the same
+// part, taking the same parameters as /multipart/presigned, so the upload
+// can carry on instead of starting over. Unlike /multipart/presigned it first
+// checks with S3 that the upload still exists, since a client that has been
+// away long enough for its URL to expire may find the upload aborted, for
+// example by a lifecycle rule, and should restart rather than keep
+// uploading parts S3 will refuse.
+func handleRefreshPartURL(w http.ResponseWriter, r *http.Request) {
+	filename := normalizeFilename(r.URL.Query().Get("filename"))
+	uploadId := r.URL.Query().Get("uploadId")
+	if filename != "" && uploadId != "" {
+		_, err := s3Client.ListParts(r.Context(), &s3.ListPartsInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(keyPrefix + userFilename(r, filename)),
+			UploadId: aws.String(uploadId),
+			MaxParts: aws.Int32(1),
+		})
+		if hasErrorCode(err, "NoSuchUpload") {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking multipart upload: %v", err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to check multipart upload: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	// Missing parameters are reported by handlePresignPart
+	handlePresignPart(w, r)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// pingTimeout is kept short: an S3 slower than this is worth reporting as
+// down for monitoring purposes.
+const pingTimeout = 2 * time.Second
+
+// handlePing times a HeadBucket round trip to S3 from this instance, for
+// correlating slow clients with S3 latency. Unlike /readyz it always makes
+// the call, since a cached answer would say nothing about latency now.
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	latency := time.Since(start)
+	if err != nil {
+		log.Printf("Ping to S3 failed after %s: %v", latency, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"latencyMs": latency.Milliseconds(),
+		"ok":        err == nil,
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// presigner is the part of *s3.PresignClient the handlers rely on, kept as an
+// interface so a fake can stand in for it.
+type presigner interface {
+	PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignHeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignUploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// skewedSigner backdates the signing time of presigned URLs so clients whose
+// clocks run behind ours don't get "request is not yet valid" errors. Expiry is
+// still counted from the backdated time, so each URL's usable lifetime shrinks
+// by the skew.
+type skewedSigner struct {
+	signer *v4.Signer
+	skew   time.Duration
+}
+
+func (s skewedSigner) PresignHTTP(ctx context.Context, credentials aws.Credentials, r *http.Request, payloadHash string, service string, region string, signingTime time.Time, optFns ...func(*v4.SignerOptions)) (string, http.Header, error) {
+	return s.signer.PresignHTTP(ctx, credentials, r, payloadHash, service, region, signingTime.Add(-s.skew), optFns...)
+}
+
+// newPresignClient builds the presign client, signing skew in the past when
+// skew is non-zero.
+func newPresignClient(client *s3.Client, skew time.Duration) *s3.PresignClient {
+	if skew == 0 {
+		return s3.NewPresignClient(client)
+	}
+	return s3.NewPresignClient(client, func(o *s3.PresignOptions) {
+		o.Presigner = skewedSigner{
+			// Matches the SDK's default presigner, which leaves S3 keys unescaped
+			signer: v4.NewSigner(func(so *v4.SignerOptions) {
+				so.DisableURIPathEscaping = true
+			}),
+			skew: skew,
+		}
+	})
+}
+
+// maxPresignExpiry is the longest X-Amz-Expires SigV4 allows.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// uploadExpiry, downloadExpiry and headExpiry, from UPLOAD_EXPIRY,
+// DOWNLOAD_EXPIRY and HEAD_EXPIRY, are how long each kind of presigned URL
+// lasts unless the request's expires parameter asks for another expiry up to
+// presignExpiryLimit (PRESIGN_EXPIRY_LIMIT).
+var (
+	uploadExpiry       = 15 * time.Minute
+	downloadExpiry     = time.Hour
+	headExpiry         = 5 * time.Minute
+	presignExpiryLimit = 24 * time.Hour
+)
+
+// requestedExpiry returns the expiry asked for in seconds by the expires
+// parameter, or def without one.
+func requestedExpiry(query url.Values, def time.Duration) (time.Duration, error) {
+	v := query.Get("expires")
+	if v == "" {
+		return def, nil
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > presignExpiryLimit {
+		return 0, fmt.Errorf("must be between 1 and %d seconds", int(presignExpiryLimit.Seconds()))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// presignExpires returns the presign option for URLs valid for d, cut short
+// to what is left of the credentials' lifetime when they are temporary: S3
+// stops honoring a URL once the credentials that signed it expire, whatever
+// X-Amz-Expires says.
+func presignExpires(ctx context.Context, d time.Duration) func(*s3.PresignOptions) {
+	// Errors are left for the presign itself to report
+	creds, err := s3Client.Options().Credentials.Retrieve(ctx)
+	if err != nil || !creds.CanExpire {
+		return s3.WithPresignExpires(d)
+	}
+	remaining := time.Until(creds.Expires).Truncate(time.Second)
+	if remaining >= d {
+		return s3.WithPresignExpires(d)
+	}
+	// X-Amz-Expires must be at least a second
+	remaining = max(remaining, time.Second)
+	log.Printf("Clamping presign expiry from %s to %s to match the signing credentials' expiry", d, remaining)
+	return s3.WithPresignExpires(remaining)
+}
+
+// presignUnsignedPayload, from PRESIGN_UNSIGNED_PAYLOAD, keeps the SDK's
+// default of signing presigned PUTs with UNSIGNED-PAYLOAD, where S3 accepts
+// any body and the client sends no x-amz-content-sha256. Turning it off is
+// for S3-compatible stores that refuse unsigned payloads: /generate then
+// takes the body's SHA-256 in contentSha256 and signs for exactly that body.
+var presignUnsignedPayload = true
+
+// withPayloadHash signs a presigned PUT for the payload with the given
+// hex-encoded SHA-256. x-amz-content-sha256 becomes a signed header the
+// client must send with that same value, and S3 rejects a body that doesn't
+// hash to it.
+func withPayloadHash(hash string) func(*s3.PresignOptions) {
+	return func(o *s3.PresignOptions) {
+		o.ClientOptions = append(o.ClientOptions, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+				// The presigner only falls back to UNSIGNED-PAYLOAD when no
+				// hash is set on the context
+				return stack.Build.Add(middleware.BuildMiddlewareFunc("PresignPayloadHash", func(
+					ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler,
+				) (middleware.BuildOutput, middleware.Metadata, error) {
+					if req, ok := in.Request.(*smithyhttp.Request); ok {
+						req.Header.Set("X-Amz-Content-Sha256", hash)
+					}
+					return next.HandleBuild(v4.SetPayloadHash(ctx, hash), in)
+				}), middleware.After)
+			})
+		})
+	}
+}
+
+// sha256Pattern matches a hex-encoded SHA-256 as x-amz-content-sha256
+// expects it.
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// presignedHost, when set, is the host every presigned URL must name: the
+// PRESIGN_HOST domain, or else the bucket's S3 or access point endpoint.
+var presignedHost string
+
+// endpointHost resolves the host client's presigns address bucket at, with
+// the client's region and its UseARNRegion for access points.
+func endpointHost(ctx context.Context, client *s3.Client, bucket string) (string, error) {
+	o := client.Options()
+	resolver := o.EndpointResolverV2
+	if resolver == nil {
+		resolver = s3.NewDefaultEndpointResolverV2()
+	}
+	endpoint, err := resolver.ResolveEndpoint(ctx, s3.EndpointParameters{
+		Bucket:         aws.String(bucket),
+		Region:         aws.String(o.Region),
+		UseArnRegion:   aws.Bool(o.UseARNRegion),
+		ForcePathStyle: aws.Bool(o.UsePathStyle),
+	})
+	if err != nil {
+		return "", err
+	}
+	return endpoint.URI.Host, nil
+}
+
+// validatePresignedURL catches presigns that succeeded but produced something
+// unusable, typically because the region or endpoint is misconfigured, or a
+// URL that doesn't address key.
+func validatePresignedURL(raw, key string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("not an absolute http(s) URL")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("empty host")
+	}
+	// An empty region leaves holes like "bucket.s3..amazonaws.com"
+	if strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") || strings.Contains(host, "..") {
+		return fmt.Errorf("malformed host %q", host)
+	}
+	if presignedHost != "" && u.Host != presignedHost {
+		return fmt.Errorf("host %q is not %s", u.Host, presignedHost)
+	}
+	// Characters such as ?, #, & and + are legal in keys but not literally in
+	// a URL path. Left unescaped they would cut the key short or have S3 read
+	// it as another one, so the client would PUT somewhere other than where we
+	// told it the object lives.
+	if !strings.HasSuffix(u.Path, "/"+key) {
+		return fmt.Errorf("path %s does not address key %q", u.EscapedPath(), key)
+	}
+	return nil
+}
+
+// hostRewritingPresigner swaps the scheme and host of every presigned URL for
+// base, e.g. a custom domain in front of S3. The signature still covers the
+// original S3 host, so whatever serves base must forward requests to S3 with
+// the Host header set back to that host; a proxy passing the client's Host
+// through will get SignatureDoesNotMatch.
+type hostRewritingPresigner struct {
+	presigner
+	base *url.URL
+}
+
+func (p hostRewritingPresigner) rewrite(req *v4.PresignedHTTPRequest, err error) (*v4.PresignedHTTPRequest, error) {
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = p.base.Scheme
+	u.Host = p.base.Host
+	req.URL = u.String()
+	return req, nil
+}
+
+func (p hostRewritingPresigner) PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return p.rewrite(p.presigner.PresignPutObject(ctx, params, optFns...))
+}
+
+func (p hostRewritingPresigner) PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return p.rewrite(p.presigner.PresignGetObject(ctx, params, optFns...))
+}
+
+func (p hostRewritingPresigner) PresignHeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return p.rewrite(p.presigner.PresignHeadObject(ctx, params, optFns...))
+}
+
+func (p hostRewritingPresigner) PresignUploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return p.rewrite(p.presigner.PresignUploadPart(ctx, params, optFns...))
+}
+
+// parsePresignHost validates PRESIGN_HOST, which must be a bare scheme and
+// host such as "https://cdn.example.com".
+func parsePresignHost(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("must be an http(s) URL with a host")
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return nil, fmt.Errorf("must not have a path, query, fragment or user info")
+	}
+	return u, nil
+}
+
+// signedHeaderAllowlist, from PRESIGN_SIGNED_HEADERS, limits which headers a
+// presigned PUT may sign besides host. Entries ending in "*" match by prefix,
+// e.g. "x-amz-meta-*". A request whose options would sign anything else, say
+// overwrite=false needing If-None-Match, is refused rather than quietly
+// signed differently. It is nil when any header may be signed.
+var signedHeaderAllowlist []string
+
+func parseSignedHeaderAllowlist(entries []string) []string {
+	if len(entries) == 0 {
+		return nil
+	}
+	allowed := make([]string, len(entries))
+	for i, entry := range entries {
+		allowed[i] = strings.ToLower(entry)
+	}
+	return allowed
+}
+
+func signedHeaderAllowed(name string) bool {
+	if signedHeaderAllowlist == nil {
+		return true
+	}
+	for _, entry := range signedHeaderAllowlist {
+		if prefix, ok := strings.CutSuffix(entry, "*"); entry == name || (ok && strings.HasPrefix(name, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientSignedHeaders returns, sorted, the headers other than host that a
+// client must send unchanged with a presigned request, and an error if one of
+// them isn't on the allowlist. Any header not listed may be added freely,
+// since S3 only checks the ones that were signed.
+func clientSignedHeaders(signed http.Header) ([]string, error) {
+	var names []string
+	for name := range signed {
+		name = strings.ToLower(name)
+		if name == "host" {
+			continue
+		}
+		if !signedHeaderAllowed(name) {
+			return nil, fmt.Errorf("the request would sign the %s header, which PRESIGN_SIGNED_HEADERS does not allow", name)
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
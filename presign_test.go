@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakePresigner records what handlers ask it to sign and returns a URL
+// addressing the key, without signing anything.
+type fakePresigner struct {
+	mu    sync.Mutex
+	puts  []*s3.PutObjectInput
+	gets  []*s3.GetObjectInput
+	heads []*s3.HeadObjectInput
+	parts []*s3.UploadPartInput
+}
+
+func useFakePresigner(t *testing.T) *fakePresigner {
+	p := &fakePresigner{}
+	setGlobal(t, &presignClient, presigner(p))
+	return p
+}
+
+func fakePresignedURL(key string, query url.Values) *v4.PresignedHTTPRequest {
+	u := url.URL{Scheme: "https", Host: "b.s3.us-east-1.amazonaws.com", Path: "/" + key, RawQuery: query.Encode()}
+	return &v4.PresignedHTTPRequest{
+		URL:          u.String(),
+		SignedHeader: http.Header{"Host": {u.Host}},
+	}
+}
+
+func (p *fakePresigner) PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.puts = append(p.puts, params)
+	return fakePresignedURL(aws.ToString(params.Key), nil), nil
+}
+
+func (p *fakePresigner) PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gets = append(p.gets, params)
+	return fakePresignedURL(aws.ToString(params.Key), nil), nil
+}
+
+func (p *fakePresigner) PresignHeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.heads = append(p.heads, params)
+	return fakePresignedURL(aws.ToString(params.Key), nil), nil
+}
+
+func (p *fakePresigner) PresignUploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.parts = append(p.parts, params)
+	return fakePresignedURL(aws.ToString(params.Key), url.Values{
+		"partNumber": {strconv.Itoa(int(aws.ToInt32(params.PartNumber)))},
+		"uploadId":   {aws.ToString(params.UploadId)},
+	}), nil
+}
+
+var partKeyTests = []struct {
+	name     string
+	filename string
+	uploadId string
+}{
+	{"plain", "video.mp4", "abc123"},
+	{"spaces", "my holiday video.mp4", "abc123"},
+	{"unicode", "vidéo 日本.mp4", "abc123"},
+	{"reserved characters", "a+b&c=d.mp4", "id/with+slash="},
+}
+
+func TestPresignPartInput(t *testing.T) {
+	fakeS3(t, nil)
+	for _, tt := range partKeyTests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := useFakePresigner(t)
+			target := "/multipart/presigned?" + url.Values{
+				"filename":   {tt.filename},
+				"uploadId":   {tt.uploadId},
+				"partNumber": {"7"},
+			}.Encode()
+			rec := serve(t, http.MethodGet, target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			if len(p.parts) != 1 {
+				t.Fatalf("presigned %d parts, want 1", len(p.parts))
+			}
+			part := p.parts[0]
+			if got, want := aws.ToString(part.Key), keyPrefix+tt.filename; got != want {
+				t.Errorf("Key = %q, want %q", got, want)
+			}
+			if got := aws.ToString(part.UploadId); got != tt.uploadId {
+				t.Errorf("UploadId = %q, want %q", got, tt.uploadId)
+			}
+			if got := aws.ToInt32(part.PartNumber); got != 7 {
+				t.Errorf("PartNumber = %d, want 7", got)
+			}
+		})
+	}
+}
+
+// The real presign client must escape the key into the path and carry the
+// part's parameters in the query, whatever characters they hold.
+func TestPresignPartURL(t *testing.T) {
+	fakeS3(t, nil)
+	for _, tt := range partKeyTests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/multipart/presigned?" + url.Values{
+				"filename":   {tt.filename},
+				"uploadId":   {tt.uploadId},
+				"partNumber": {"7"},
+			}.Encode()
+			rec := serve(t, http.MethodGet, target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			var resp struct {
+				URL string `json:"url"`
+			}
+			decodeJSON(t, rec, &resp)
+			u, err := url.Parse(resp.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "/" + bucket + "/" + keyPrefix + tt.filename; u.Path != want {
+				t.Errorf("path = %q, want %q", u.Path, want)
+			}
+			if strings.ContainsAny(u.RawPath, " ") || strings.ContainsAny(u.EscapedPath(), " +&=") {
+				t.Errorf("path %q is not escaped", u.EscapedPath())
+			}
+			query := u.Query()
+			if got := query.Get("partNumber"); got != "7" {
+				t.Errorf("partNumber = %q, want 7", got)
+			}
+			if got := query.Get("uploadId"); got != tt.uploadId {
+				t.Errorf("uploadId = %q, want %q", got, tt.uploadId)
+			}
+			for _, name := range []string{"X-Amz-Signature", "X-Amz-Credential", "X-Amz-Expires"} {
+				if query.Get(name) == "" {
+					t.Errorf("%s is missing", name)
+				}
+			}
+		})
+	}
+}
+
+func TestPresignClockSkew(t *testing.T) {
+	client := s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AK", "SK", ""),
+	})
+	tests := []struct {
+		name string
+		skew time.Duration
+	}{
+		{"none", 0},
+		{"five minutes", 5 * time.Minute},
+		{"an hour", time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now().UTC().Truncate(time.Second)
+			req, err := newPresignClient(client, tt.skew).PresignGetObject(context.Background(), &s3.GetObjectInput{
+				Bucket: aws.String("photos"),
+				Key:    aws.String(keyPrefix + "a b.png"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(req.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := time.Parse("20060102T150405Z", u.Query().Get("X-Amz-Date"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := before.Add(-tt.skew); signed.Before(want) || signed.After(want.Add(5*time.Second)) {
+				t.Errorf("X-Amz-Date = %s, want %s", signed, want)
+			}
+			// The skewed signer must escape the key as the default one does
+			if want := "/" + keyPrefix + "a%20b.png"; u.EscapedPath() != want {
+				t.Errorf("path = %q, want %q", u.EscapedPath(), want)
+			}
+		})
+	}
+}
+
+func TestParsePresignHost(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"https://files.example.com", "https://files.example.com", false},
+		{"http://localhost:9000/", "http://localhost:9000/", false},
+		{"files.example.com", "", true},
+		{"ftp://files.example.com", "", true},
+		{"https://files.example.com/uploads", "", true},
+		{"https://files.example.com?x=1", "", true},
+		{"https://user@files.example.com", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parsePresignHost(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePresignHost(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("parsePresignHost(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostRewritingPresigner(t *testing.T) {
+	base, err := parsePresignHost("http://files.example.com:8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := hostRewritingPresigner{presigner: &fakePresigner{}, base: base}
+	key := keyPrefix + "a b.png"
+	tests := []struct {
+		name    string
+		presign func() (*v4.PresignedHTTPRequest, error)
+	}{
+		{"PUT", func() (*v4.PresignedHTTPRequest, error) {
+			return p.PresignPutObject(context.Background(), &s3.PutObjectInput{Key: aws.String(key)})
+		}},
+		{"GET", func() (*v4.PresignedHTTPRequest, error) {
+			return p.PresignGetObject(context.Background(), &s3.GetObjectInput{Key: aws.String(key)})
+		}},
+		{"HEAD", func() (*v4.PresignedHTTPRequest, error) {
+			return p.PresignHeadObject(context.Background(), &s3.HeadObjectInput{Key: aws.String(key)})
+		}},
+		{"part", func() (*v4.PresignedHTTPRequest, error) {
+			return p.PresignUploadPart(context.Background(), &s3.UploadPartInput{Key: aws.String(key), PartNumber: aws.Int32(2), UploadId: aws.String("U1")})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := tt.presign()
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(req.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Scheme != "http" || u.Host != "files.example.com:8443" {
+				t.Errorf("URL %s not rewritten to %s", req.URL, base)
+			}
+			if err := validatePresignedURL(req.URL, key); err != nil {
+				t.Errorf("validatePresignedURL: %v", err)
+			}
+			// The signature still covers S3's own host
+			if got := req.SignedHeader.Get("Host"); got != "b.s3.us-east-1.amazonaws.com" {
+				t.Errorf("signed Host = %q, want S3's", got)
+			}
+		})
+	}
+}
+
+func TestClientSignedHeaders(t *testing.T) {
+	signed := http.Header{
+		"Host":           {"b.s3.amazonaws.com"},
+		"If-None-Match":  {"*"},
+		"X-Amz-Meta-Env": {"prod"},
+		"X-Amz-Acl":      {"private"},
+	}
+	tests := []struct {
+		name      string
+		allowlist []string
+		want      []string
+		wantErr   bool
+	}{
+		{"no allowlist", nil, []string{"if-none-match", "x-amz-acl", "x-amz-meta-env"}, false},
+		{"all allowed", []string{"If-None-Match", "x-amz-acl", "x-amz-meta-*"}, []string{"if-none-match", "x-amz-acl", "x-amz-meta-env"}, false},
+		{"prefix doesn't cover it", []string{"if-none-match", "x-amz-acl", "x-amz-meta-app-*"}, nil, true},
+		{"one missing", []string{"x-amz-acl", "x-amz-meta-*"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &signedHeaderAllowlist, parseSignedHeaderAllowlist(tt.allowlist))
+			got, err := clientSignedHeaders(signed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clientSignedHeaders error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("clientSignedHeaders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSignedHeaderAllowlist(t *testing.T) {
+	fakeS3(t, nil)
+	setGlobal(t, &signedHeaderAllowlist, parseSignedHeaderAllowlist([]string{"x-amz-meta-*"}))
+	tests := []struct {
+		name          string
+		query         string
+		status        int
+		signedHeaders string
+	}{
+		{"nothing extra", "filename=a.txt", http.StatusOK, ""},
+		{"allowed metadata", "filename=a.txt&meta=env:prod", http.StatusOK, "x-amz-meta-env"},
+		{"If-None-Match isn't allowed", "filename=a.txt&overwrite=false", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, http.MethodGet, "/generate?"+tt.query, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get("X-Signed-Headers"); got != tt.signedHeaders {
+				t.Errorf("X-Signed-Headers = %q, want %q", got, tt.signedHeaders)
+			}
+		})
+	}
+}
+
+func TestPresignExpiresClamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		canExpire bool
+		remaining time.Duration
+		expiry    time.Duration
+		want      time.Duration
+	}{
+		{"static credentials", false, 0, time.Hour, time.Hour},
+		{"credentials outlive the URL", true, 2 * time.Hour, time.Hour, time.Hour},
+		{"credentials expire first", true, 10*time.Minute + 500*time.Millisecond, time.Hour, 10 * time.Minute},
+		{"credentials already expired", true, -time.Minute, time.Hour, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expires := time.Now().Add(tt.remaining)
+			setGlobal(t, &s3Client, s3.New(s3.Options{
+				Region: "us-east-1",
+				Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+					return aws.Credentials{AccessKeyID: "AK", SecretAccessKey: "SK", CanExpire: tt.canExpire, Expires: expires}, nil
+				}),
+			}))
+			var o s3.PresignOptions
+			presignExpires(context.Background(), tt.expiry)(&o)
+			if o.Expires != tt.want {
+				t.Errorf("expires = %s, want %s", o.Expires, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratePayloadHash(t *testing.T) {
+	fakeS3(t, nil)
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		name          string
+		unsigned      bool
+		query         string
+		status        int
+		signedHeaders string
+	}{
+		{"unsigned payload", true, "filename=a.txt", http.StatusOK, ""},
+		{"unsigned payload ignores contentSha256", true, "filename=a.txt&contentSha256=" + hash, http.StatusOK, ""},
+		{"signed payload", false, "filename=a.txt&contentSha256=" + hash, http.StatusOK, "x-amz-content-sha256"},
+		{"missing contentSha256", false, "filename=a.txt", http.StatusBadRequest, ""},
+		{"uppercase contentSha256", false, "filename=a.txt&contentSha256=" + strings.ToUpper(hash), http.StatusBadRequest, ""},
+		{"short contentSha256", false, "filename=a.txt&contentSha256=" + hash[:62], http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &presignUnsignedPayload, tt.unsigned)
+			rec := serve(t, http.MethodGet, "/generate?"+tt.query, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get("X-Signed-Headers"); got != tt.signedHeaders {
+				t.Errorf("X-Signed-Headers = %q, want %q", got, tt.signedHeaders)
+			}
+		})
+	}
+}
+
+func TestValidatePresignedURL(t *testing.T) {
+	const key = "uploads/a b.txt"
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"virtual-hosted", "https://b.s3.us-east-1.amazonaws.com/uploads/a%20b.txt?X-Amz-Signature=x", false},
+		{"path-style", "http://localhost:9000/b/uploads/a%20b.txt", false},
+		{"relative", "/b/uploads/a%20b.txt", true},
+		{"other scheme", "ftp://b.s3.amazonaws.com/uploads/a%20b.txt", true},
+		{"empty region", "https://b.s3..amazonaws.com/uploads/a%20b.txt", true},
+		{"trailing dot", "https://b.s3.amazonaws.com./uploads/a%20b.txt", true},
+		{"other key", "https://b.s3.amazonaws.com/uploads/other.txt", true},
+		{"unparseable", "https://b.s3.amazonaws.com/%zz", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePresignedURL(tt.url, key); (err != nil) != tt.wantErr {
+				t.Errorf("validatePresignedURL(%q) = %v, want error %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+
+	// Unescaped, these characters end the path or turn part of the key into
+	// a query or fragment
+	for _, key := range []string{"uploads/a?b.txt", "uploads/a#b.txt"} {
+		raw := "https://b.s3.amazonaws.com/" + key
+		if err := validatePresignedURL(raw, key); err == nil {
+			t.Errorf("validatePresignedURL(%q) accepted the unescaped key", raw)
+		}
+	}
+}
+
+func TestEndpointHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		region string
+		bucket string
+		optFns []func(*s3.Options)
+		want   string
+	}{
+		{"bucket", "us-east-1", "photos", nil, "photos.s3.us-east-1.amazonaws.com"},
+		{"bucket in another region", "eu-west-2", "photos", nil, "photos.s3.eu-west-2.amazonaws.com"},
+		{"path-style", "us-east-1", "photos", []func(*s3.Options){func(o *s3.Options) { o.UsePathStyle = true }}, "s3.us-east-1.amazonaws.com"},
+		{"access point in its own region", "us-east-1", "arn:aws:s3:eu-west-2:123456789012:accesspoint/ap",
+			[]func(*s3.Options){func(o *s3.Options) { o.UseARNRegion = true }}, "ap-123456789012.s3-accesspoint.eu-west-2.amazonaws.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := s3.NewFromConfig(aws.Config{
+				Region:      tt.region,
+				Credentials: credentials.NewStaticCredentialsProvider("AK", "SK", ""),
+			}, tt.optFns...)
+			got, err := endpointHost(context.Background(), client, tt.bucket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("endpointHost = %q, want %q", got, tt.want)
+			}
+
+			// What the SDK actually presigns must pass the check
+			setGlobal(t, &presignedHost, got)
+			req, err := s3.NewPresignClient(client).PresignGetObject(context.Background(), &s3.GetObjectInput{
+				Bucket: aws.String(tt.bucket),
+				Key:    aws.String(keyPrefix + "a.png"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := validatePresignedURL(req.URL, keyPrefix+"a.png"); err != nil {
+				t.Errorf("validatePresignedURL(%q): %v", req.URL, err)
+			}
+		})
+	}
+}
+
+func TestValidatePresignedURLHost(t *testing.T) {
+	const key = "uploads/a.txt"
+	tests := []struct {
+		name    string
+		host    string
+		url     string
+		wantErr bool
+	}{
+		{"unset accepts any host", "", "https://elsewhere.example/uploads/a.txt", false},
+		{"bucket endpoint", "b.s3.us-east-1.amazonaws.com", "https://b.s3.us-east-1.amazonaws.com/uploads/a.txt", false},
+		{"other region", "b.s3.us-east-1.amazonaws.com", "https://b.s3.eu-west-2.amazonaws.com/uploads/a.txt", true},
+		{"other bucket", "b.s3.us-east-1.amazonaws.com", "https://c.s3.us-east-1.amazonaws.com/uploads/a.txt", true},
+		{"PRESIGN_HOST", "cdn.example.com", "https://cdn.example.com/uploads/a.txt", false},
+		{"PRESIGN_HOST port", "cdn.example.com:8443", "https://cdn.example.com/uploads/a.txt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &presignedHost, tt.host)
+			if err := validatePresignedURL(tt.url, key); (err != nil) != tt.wantErr {
+				t.Errorf("validatePresignedURL(%q) with host %q = %v, want error %v", tt.url, tt.host, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateWrongHost(t *testing.T) {
+	fakeS3(t, nil)
+	setGlobal(t, &presignedHost, "b.s3.eu-west-2.amazonaws.com")
+
+	rec := serve(t, http.MethodGet, "/generate?filename=a.txt", "")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500 for a URL signed for us-east-1; body %q", rec.Code, rec.Body)
+	}
+}
+
+// Keys with characters reserved in URLs must come back from the real
+// presigner escaped so that S3 sees the same key.
+func TestGenerateReservedCharacters(t *testing.T) {
+	fakeS3(t, nil)
+	for _, filename := range []string{"a?b.txt", "a#b.txt", "a&b=c.txt", "a+b.txt", "100%.txt", "a b;c.txt"} {
+		t.Run(filename, func(t *testing.T) {
+			rec := serve(t, http.MethodGet, "/generate?"+url.Values{"filename": {filename}}.Encode(), "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			u, err := url.Parse(rec.Body.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "/" + bucket + "/" + keyPrefix + filename; u.Path != want {
+				t.Errorf("path = %q, want %q", u.Path, want)
+			}
+			if u.Fragment != "" {
+				t.Errorf("fragment = %q, want none", u.Fragment)
+			}
+		})
+	}
+}
+
+func TestRequestedExpiry(t *testing.T) {
+	setGlobal(t, &presignExpiryLimit, time.Hour)
+	tests := []struct {
+		name    string
+		expires string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", "", 15 * time.Minute, false},
+		{"shorter", "60", time.Minute, false},
+		{"longer", "1800", 30 * time.Minute, false},
+		{"at the limit", "3600", time.Hour, false},
+		{"over the limit", "3601", 0, true},
+		{"zero", "0", 0, true},
+		{"negative", "-1", 0, true},
+		{"duration syntax", "1h", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			if tt.expires != "" {
+				query.Set("expires", tt.expires)
+			}
+			got, err := requestedExpiry(query, 15*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("requestedExpiry(%q) error = %v, want error %v", tt.expires, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("requestedExpiry(%q) = %s, want %s", tt.expires, got, tt.want)
+			}
+		})
+	}
+}
+
+// Each kind of URL gets its own default expiry, and any of them can be
+// overridden up to PRESIGN_EXPIRY_LIMIT.
+func TestPresignExpiries(t *testing.T) {
+	fakeS3(t, nil)
+	setGlobal(t, &uploadExpiry, 10*time.Minute)
+	setGlobal(t, &downloadExpiry, 2*time.Hour)
+	setGlobal(t, &headExpiry, time.Minute)
+	setGlobal(t, &presignExpiryLimit, 3*time.Hour)
+	tests := []struct {
+		name    string
+		target  string
+		status  int
+		expires string
+	}{
+		{"upload default", "/generate?filename=a.txt", http.StatusOK, "600"},
+		{"download default", "/download?filename=a.txt", http.StatusOK, "7200"},
+		{"head default", "/head?filename=a.txt", http.StatusOK, "60"},
+		{"part default", "/multipart/presigned?filename=a.txt&uploadId=U1&partNumber=1", http.StatusOK, "600"},
+		{"upload override", "/generate?filename=a.txt&expires=120", http.StatusOK, "120"},
+		{"download override", "/download?filename=a.txt&expires=10800", http.StatusOK, "10800"},
+		{"head override", "/head?filename=a.txt&expires=30", http.StatusOK, "30"},
+		{"upload over the limit", "/generate?filename=a.txt&expires=10801", http.StatusBadRequest, ""},
+		{"download over the limit", "/download?filename=a.txt&expires=10801", http.StatusBadRequest, ""},
+		{"head over the limit", "/head?filename=a.txt&expires=10801", http.StatusBadRequest, ""},
+	}
+	expiresParam := regexp.MustCompile(`X-Amz-Expires=(\d+)`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, http.MethodGet, tt.target, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+			if tt.expires == "" {
+				return
+			}
+			m := expiresParam.FindStringSubmatch(rec.Body.String())
+			if m == nil {
+				t.Fatalf("no X-Amz-Expires in %q", rec.Body)
+			}
+			if m[1] != tt.expires {
+				t.Errorf("X-Amz-Expires = %s, want %s", m[1], tt.expires)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// publishPrefix, from PUBLISH_PREFIX, is where completed uploads are copied
+// when the client asks for them to be published, keeping finished assets
+// apart from the uploads still landing under keyPrefix.
+var publishPrefix string
+
+// publishACL and publishGrants, from PUBLISH_ACL and the PUBLISH_GRANT_*
+// settings, are set on published copies, since CopyObject never carries over
+// the source's ACL. They let the published prefix be owned differently from
+// the uploads, such as with bucket-owner-full-control when publishing into a
+// bucket in another account. With none of them set, copies get DEFAULT_ACL
+// and the GRANT_* grants like every other object we write.
+var (
+	publishACL    types.ObjectCannedACL
+	publishGrants objectGrants
+)
+
+// publishedKey maps an uploaded key to its place under publishPrefix.
+func publishedKey(key string) string {
+	return publishPrefix + strings.TrimPrefix(key, keyPrefix)
+}
+
+// publishReplacement swaps the metadata of a published copy for its own. The
+// content type is carried over from the upload unless it sets one, but
+// anything else S3 counts as metadata, such as Cache-Control, is dropped.
+type publishReplacement struct {
+	metadata    map[string]string
+	contentType string
+}
+
+// publishObject copies key to publishPrefix and returns the published key.
+// The copy keeps the upload's metadata unless replace is set. CopyObject is
+// limited to 5 GiB, so larger uploads can't be published this way.
+func publishObject(ctx context.Context, key string, replace *publishReplacement) (string, error) {
+	head, err := headObject(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if aws.ToInt64(head.ContentLength) > maxPutObjectSize {
+		return "", fmt.Errorf("objects over %d bytes can't be published", int64(maxPutObjectSize))
+	}
+
+	dest := publishedKey(key)
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(dest),
+		CopySource:        aws.String(copySourceFor(key)),
+		CopySourceIfMatch: head.ETag,
+		MetadataDirective: types.MetadataDirectiveCopy,
+		ACL:               publishACL,
+		GrantFullControl:  publishGrants.FullControl,
+		GrantRead:         publishGrants.Read,
+		GrantReadACP:      publishGrants.ReadACP,
+		GrantWriteACP:     publishGrants.WriteACP,
+		BucketKeyEnabled:  bucketKeyEnabled(),
+	}
+	if replace != nil {
+		input.MetadataDirective = types.MetadataDirectiveReplace
+		input.ContentType = head.ContentType
+		if replace.contentType != "" {
+			input.ContentType = aws.String(replace.contentType)
+		}
+		// Replaced metadata still gets the defaults every upload has
+		input.Metadata = make(map[string]string, len(defaultMetadata)+len(replace.metadata))
+		for k, v := range defaultMetadata {
+			input.Metadata[k] = v
+		}
+		for k, v := range replace.metadata {
+			input.Metadata[k] = v
+		}
+	}
+	_, err = s3Client.CopyObject(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	invalidator.invalidate(dest)
+	objectHeads.invalidate(dest)
+	return dest, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// publishS3 answers the CompleteMultipartUpload, HeadObject and CopyObject of
+// a published /multipart/complete, returning the CopyObject request.
+func publishS3(t *testing.T) **http.Request {
+	var copied *http.Request
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(`<CompleteMultipartUploadResult/>`))
+		case http.MethodHead:
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Header().Set("ETag", `"abc"`)
+			w.Header().Set("Content-Length", "10")
+		case http.MethodPut:
+			copied = r
+			w.Write([]byte(`<CopyObjectResult><ETag>"abc"</ETag></CopyObjectResult>`))
+		}
+	})
+	setGlobal(t, &publishPrefix, "published/")
+	return &copied
+}
+
+// publishBody is a published /multipart/complete for one part, with extra
+// JSON fields appended.
+func publishBody(extra string) string {
+	return `{"key":"` + keyPrefix + `big.mp4","uploadId":"U1","parts":[{"eTag":"\"e1\"","partNumber":1}],"publish":true` + extra + `}`
+}
+
+func TestPublishMetadataDirective(t *testing.T) {
+	tests := []struct {
+		name        string
+		extra       string
+		defaults    map[string]string
+		directive   string
+		contentType string
+		metadata    map[string]string
+	}{
+		{"copy by default", ``, nil, "COPY", "", nil},
+		{"explicit copy", `,"metadataDirective":"copy"`, nil, "COPY", "", nil},
+		{"replace keeps the content type", `,"metadataDirective":"REPLACE","metadata":{"Env":"prod"}`, nil, "REPLACE", "video/mp4", map[string]string{"env": "prod"}},
+		{"replace the content type", `,"metadataDirective":"REPLACE","contentType":"video/webm"`, nil, "REPLACE", "video/webm", nil},
+		{"replace keeps the defaults", `,"metadataDirective":"REPLACE","metadata":{"env":"prod"}`, map[string]string{"app": "uploader", "env": "dev"}, "REPLACE", "video/mp4", map[string]string{"app": "uploader", "env": "prod"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied := publishS3(t)
+			setGlobal(t, &defaultMetadata, tt.defaults)
+			rec := serve(t, http.MethodPost, "/multipart/complete", publishBody(tt.extra))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			r := *copied
+			if r == nil {
+				t.Fatal("CopyObject was not called")
+			}
+			if got, want := r.URL.Path, "/b/published/big.mp4"; got != want {
+				t.Errorf("copied to %q, want %q", got, want)
+			}
+			if got := r.Header.Get("X-Amz-Metadata-Directive"); got != tt.directive {
+				t.Errorf("x-amz-metadata-directive = %q, want %q", got, tt.directive)
+			}
+			if got := r.Header.Get("Content-Type"); tt.contentType != "" && got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			metadata := map[string]string{}
+			for name, values := range r.Header {
+				if k, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+					metadata[k] = values[0]
+				}
+			}
+			if !maps.Equal(metadata, tt.metadata) && len(metadata)+len(tt.metadata) > 0 {
+				t.Errorf("metadata = %v, want %v", metadata, tt.metadata)
+			}
+		})
+	}
+}
+
+func TestPublishMetadataDirectiveValidation(t *testing.T) {
+	publishS3(t)
+	tests := []struct {
+		name   string
+		extra  string
+		fields []string
+	}{
+		{"unknown directive", `,"metadataDirective":"MERGE"`, []string{"metadataDirective"}},
+		{"metadata without REPLACE", `,"metadata":{"env":"prod"}`, []string{"metadataDirective"}},
+		{"contentType without REPLACE", `,"metadataDirective":"COPY","contentType":"video/webm"`, []string{"metadataDirective"}},
+		{"invalid metadata", `,"metadataDirective":"REPLACE","metadata":{"bad key":"x"}`, []string{"metadata"}},
+		{"invalid contentType", `,"metadataDirective":"REPLACE","contentType":"not a type"`, []string{"contentType"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, http.MethodPost, "/multipart/complete", publishBody(tt.extra))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400; body %q", rec.Code, rec.Body)
+			}
+			if got := errorFields(t, rec); !slices.Equal(got, tt.fields) {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+// ownerGrantee grants to another account, e.g. the one owning the bucket.
+var ownerGrantee = `id="` + strings.Repeat("a", 64) + `"`
+
+func TestPublishACL(t *testing.T) {
+	ownerGrants, err := newObjectGrants(ownerGrantee, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		acl         types.ObjectCannedACL
+		grants      objectGrants
+		wantACL     string
+		wantControl string
+	}{
+		{"none", "", objectGrants{}, "", ""},
+		{"canned ACL", types.ObjectCannedACLBucketOwnerFullControl, objectGrants{}, "bucket-owner-full-control", ""},
+		{"grants", "", ownerGrants, "", ownerGrantee},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied := publishS3(t)
+			setGlobal(t, &publishACL, tt.acl)
+			setGlobal(t, &publishGrants, tt.grants)
+			rec := serve(t, http.MethodPost, "/multipart/complete", publishBody(""))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			r := *copied
+			if got := r.Header.Get("X-Amz-Acl"); got != tt.wantACL {
+				t.Errorf("x-amz-acl = %q, want %q", got, tt.wantACL)
+			}
+			if got := r.Header.Get("X-Amz-Grant-Full-Control"); got != tt.wantControl {
+				t.Errorf("x-amz-grant-full-control = %q, want %q", got, tt.wantControl)
+			}
+		})
+	}
+}
+
+// Buckets that enforce bucket ownership refuse ACLs, published copies
+// included.
+func TestCheckObjectOwnershipPublish(t *testing.T) {
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<OwnershipControls><Rule><ObjectOwnership>BucketOwnerEnforced</ObjectOwnership></Rule></OwnershipControls>`))
+	})
+	setGlobal(t, &aclsDisabled, false)
+	setGlobal(t, &defaultACL, "")
+	setGlobal(t, &grants, objectGrants{})
+	setGlobal(t, &publishACL, types.ObjectCannedACLBucketOwnerFullControl)
+	setGlobal(t, &publishGrants, objectGrants{FullControl: &ownerGrantee})
+
+	checkObjectOwnership(context.Background())
+	if publishACL != "" || publishGrants.set() {
+		t.Errorf("publishACL = %q, publishGrants set = %v, want both cleared", publishACL, publishGrants.set())
+	}
+}
+
+func TestValidatePublishACL(t *testing.T) {
+	tests := []struct {
+		name    string
+		acl     string
+		grant   string
+		wantErr bool
+	}{
+		{"unset", "", "", false},
+		{"canned ACL", "bucket-owner-full-control", "", false},
+		{"grants", "", ownerGrantee, false},
+		{"unknown ACL", "everyone", "", true},
+		{"ACL and grants", "private", ownerGrantee, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := defaultConfig()
+			conf.Bucket = "b"
+			conf.PublishACL = tt.acl
+			conf.PublishGrantFullControl = tt.grant
+			if err := conf.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
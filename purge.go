@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// handlePurge deletes every object under a prefix inside keyPrefix. Each
+// listed page of up to 1000 keys is deleted with one DeleteObjects call as
+// the listing proceeds, rather than collecting the whole prefix first.
+//
+// The body must repeat the prefix in confirm, so a stray or mistyped request
+// can't wipe a prefix nobody named twice.
+func handlePurge(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Prefix  string `json:"prefix"`
+		Confirm string `json:"confirm"`
+	}
+
+	var errs validationErrors
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		errs.add("body", fmt.Sprintf("invalid JSON: %v", err))
+		errs.respond(w)
+		return
+	}
+	switch {
+	case payload.Prefix == "":
+		errs.add("prefix", "is required")
+	case !strings.HasPrefix(payload.Prefix, keyPrefix) || len(payload.Prefix) == len(keyPrefix):
+		errs.add("prefix", fmt.Sprintf("must be a sub-prefix of %s", keyPrefix))
+	}
+	if payload.Confirm != payload.Prefix {
+		errs.add("confirm", "must repeat prefix")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	var deleted, failed int
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(payload.Prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err == nil && len(page.Contents) > 0 {
+			var n int
+			n, err = deleteListed(r.Context(), page.Contents)
+			deleted += n
+			failed += len(page.Contents) - n
+		}
+		if err != nil {
+			log.Printf("Error purging %s after %d deletions: %v", payload.Prefix, deleted, err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to purge prefix after deleting %d objects: %v", deleted, err), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	log.Printf("Purged %d objects under %s (%d failed)", deleted, payload.Prefix, failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"prefix":  payload.Prefix,
+		"deleted": deleted,
+		"failed":  failed,
+	})
+}
+
+// deleteListed deletes one listing page of objects and returns how many S3
+// reported as deleted.
+func deleteListed(ctx context.Context, objects []types.Object) (int, error) {
+	ids := make([]types.ObjectIdentifier, len(objects))
+	for i, obj := range objects {
+		ids[i] = types.ObjectIdentifier{Key: obj.Key}
+	}
+	resp, err := s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{
+			Objects: ids,
+			Quiet:   aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range resp.Errors {
+		log.Printf("Error deleting %s: %s %s", aws.ToString(e.Key), aws.ToString(e.Code), aws.ToString(e.Message))
+	}
+	for _, obj := range objects {
+		invalidator.invalidate(aws.ToString(obj.Key))
+		objectHeads.invalidate(aws.ToString(obj.Key))
+	}
+	return len(objects) - len(resp.Errors), nil
+}
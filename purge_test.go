@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// purgeS3 lists pages of keys under the prefix, one page per request, and
+// answers each DeleteObjects with an Error for every key in failing.
+func purgeS3(t *testing.T, pages [][]string, failing ...string) *int {
+	var deletes int
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodGet:
+			page := 0
+			fmt.Sscan(r.URL.Query().Get("continuation-token"), &page)
+			var b strings.Builder
+			b.WriteString(`<ListBucketResult>`)
+			for _, key := range pages[page] {
+				b.WriteString(`<Contents><Key>` + key + `</Key></Contents>`)
+			}
+			if page+1 < len(pages) {
+				fmt.Fprintf(&b, `<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>`, page+1)
+			}
+			b.WriteString(`</ListBucketResult>`)
+			w.Write([]byte(b.String()))
+		case r.Method == http.MethodPost:
+			deletes++
+			var b strings.Builder
+			b.WriteString(`<DeleteResult>`)
+			for _, key := range failing {
+				if strings.Contains(string(body), "<Key>"+key+"</Key>") {
+					b.WriteString(`<Error><Key>` + key + `</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+				}
+			}
+			b.WriteString(`</DeleteResult>`)
+			w.Write([]byte(b.String()))
+		}
+	})
+	setGlobal(t, &adminToken, "secret")
+	return &deletes
+}
+
+func servePurge(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/purge", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPurgeValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"no prefix", `{}`, []string{"prefix"}},
+		{"confirm mismatch", `{"prefix":"` + keyPrefix + `a/","confirm":"` + keyPrefix + `b/"}`, []string{"confirm"}},
+		{"no confirm", `{"prefix":"` + keyPrefix + `a/"}`, []string{"confirm"}},
+		{"whole key prefix", `{"prefix":"` + keyPrefix + `","confirm":"` + keyPrefix + `"}`, []string{"prefix"}},
+		{"outside key prefix", `{"prefix":"other/","confirm":"other/"}`, []string{"prefix"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deletes := purgeS3(t, [][]string{{keyPrefix + "a/1"}})
+			rec := servePurge(t, tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400; body %q", rec.Code, rec.Body)
+			}
+			if got := errorFields(t, rec); !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("error fields = %v, want %v", got, tt.fields)
+			}
+			if *deletes != 0 {
+				t.Errorf("%d DeleteObjects calls, want none", *deletes)
+			}
+		})
+	}
+}
+
+func TestPurgeCounts(t *testing.T) {
+	prefix := keyPrefix + "a/"
+	tests := []struct {
+		name        string
+		pages       [][]string
+		failing     []string
+		deleted     int
+		failed      int
+		wantDeletes int
+	}{
+		{"nothing listed", [][]string{{}}, nil, 0, 0, 0},
+		{"one page", [][]string{{prefix + "1", prefix + "2"}}, nil, 2, 0, 1},
+		{"several pages", [][]string{{prefix + "1", prefix + "2"}, {prefix + "3"}, {prefix + "4", prefix + "5"}}, nil, 5, 0, 3},
+		{"partial errors", [][]string{{prefix + "1", prefix + "2"}, {prefix + "3"}}, []string{prefix + "2", prefix + "3"}, 1, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deletes := purgeS3(t, tt.pages, tt.failing...)
+			rec := servePurge(t, `{"prefix":"`+prefix+`","confirm":"`+prefix+`"}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			var resp struct {
+				Deleted int `json:"deleted"`
+				Failed  int `json:"failed"`
+			}
+			decodeJSON(t, rec, &resp)
+			if resp.Deleted != tt.deleted || resp.Failed != tt.failed {
+				t.Errorf("deleted %d, failed %d; want %d, %d", resp.Deleted, resp.Failed, tt.deleted, tt.failed)
+			}
+			if *deletes != tt.wantDeletes {
+				t.Errorf("%d DeleteObjects calls, want %d", *deletes, tt.wantDeletes)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// userTokens maps the bearer tokens in USER_TOKENS to user IDs. When it is
+// empty, requests are anonymous and no quotas apply.
+var userTokens map[string]string
+
+type userKey struct{}
+
+// userFrom returns the user authenticated by withUser, or "" outside it.
+func userFrom(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// authenticateUser looks up the user for the request's bearer token. Every
+// configured token is compared so the lookup takes the same time whichever
+// one matches.
+func authenticateUser(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	var user string
+	for t, u := range userTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			user = u
+		}
+	}
+	return user, user != ""
+}
+
+// withUser requires a valid user token when USER_TOKENS is configured and
+// makes the user available to the handler through userFrom.
+func withUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(userTokens) == 0 {
+			next(w, r)
+			return
+		}
+		user, ok := authenticateUser(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	}
+}
+
+// withQuota is withUser for endpoints that start new uploads, additionally
+// refusing users who have used up their quota for the current window.
+func withQuota(next http.HandlerFunc) http.HandlerFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request) {
+		if user := userFrom(r.Context()); user != "" {
+			if usage, ok := quotas.hasQuota(user); !ok {
+				respondQuotaExceeded(w, usage)
+				return
+			}
+		}
+		next(w, r)
+	})
+}
+
+// quotaStore tracks bytes uploaded per user. It is an interface so the
+// in-memory store can be swapped for a shared one when running several
+// replicas.
+type quotaStore interface {
+	// usage returns what user has uploaded in the current window.
+	usage(user string) quotaUsage
+	// reserve adds n bytes to user's usage unless that would take it over
+	// the limit, reporting whether it did.
+	reserve(user string, n int64) (quotaUsage, bool)
+	// add adds n bytes unconditionally, or gives them back when negative.
+	add(user string, n int64)
+}
+
+type quotaUsage struct {
+	Used    int64
+	Limit   int64
+	ResetAt time.Time
+}
+
+// quotaLimits holds the per-window byte limit of each user, taken from their
+// tier in USER_TIERS or the "default" tier of QUOTA_TIERS. Users with
+// neither are unlimited.
+type quotaLimits struct {
+	tiers     map[string]int64
+	userTiers map[string]string
+	store     quotaStore
+}
+
+var quotas *quotaLimits
+
+func (q *quotaLimits) limit(user string) (int64, bool) {
+	tier, ok := q.userTiers[user]
+	if !ok {
+		tier = "default"
+	}
+	limit, ok := q.tiers[tier]
+	return limit, ok
+}
+
+// hasQuota reports the user's usage and whether they have quota left. The
+// receiver may be nil, meaning no quotas are configured.
+func (q *quotaLimits) hasQuota(user string) (quotaUsage, bool) {
+	if q == nil {
+		return quotaUsage{}, true
+	}
+	if _, ok := q.limit(user); !ok {
+		return quotaUsage{}, true
+	}
+	usage := q.store.usage(user)
+	return usage, usage.Used < usage.Limit
+}
+
+// charge reserves n bytes of the request user's quota, failing if that would
+// exceed it. Anonymous requests and unlimited users always succeed.
+func (q *quotaLimits) charge(ctx context.Context, n int64) (quotaUsage, bool) {
+	user := userFrom(ctx)
+	if q == nil || user == "" || n <= 0 {
+		return quotaUsage{}, true
+	}
+	if _, ok := q.limit(user); !ok {
+		return quotaUsage{}, true
+	}
+	return q.store.reserve(user, n)
+}
+
+// remaining returns how many bytes the request user has left in the current
+// window, or false when they are anonymous or unlimited.
+func (q *quotaLimits) remaining(ctx context.Context) (int64, bool) {
+	user := userFrom(ctx)
+	if q == nil || user == "" {
+		return 0, false
+	}
+	if _, ok := q.limit(user); !ok {
+		return 0, false
+	}
+	usage := q.store.usage(user)
+	return max(usage.Limit-usage.Used, 0), true
+}
+
+// record adds n bytes that were already uploaded, such as a completed
+// multipart upload, without checking the limit. Negative n refunds a charge.
+func (q *quotaLimits) record(ctx context.Context, n int64) {
+	user := userFrom(ctx)
+	if q == nil || user == "" || n == 0 {
+		return
+	}
+	if _, ok := q.limit(user); ok {
+		q.store.add(user, n)
+	}
+}
+
+// recordCompleted charges the size of a just-completed multipart upload,
+// which is only known once S3 has assembled it.
+func (q *quotaLimits) recordCompleted(ctx context.Context, key string) {
+	if q == nil || userFrom(ctx) == "" {
+		return
+	}
+	head, err := headObject(ctx, key)
+	if err != nil {
+		log.Printf("Error sizing %s for quota: %v", key, err)
+		return
+	}
+	q.record(ctx, aws.ToInt64(head.ContentLength))
+}
+
+func respondQuotaExceeded(w http.ResponseWriter, usage quotaUsage) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(usage.ResetAt).Seconds())+1))
+	http.Error(w, fmt.Sprintf("Upload quota of %d bytes exceeded (%d used), resets at %s", usage.Limit, usage.Used, usage.ResetAt.UTC().Format(time.RFC3339)), http.StatusTooManyRequests)
+}
+
+// memoryQuotaStore counts usage in fixed windows that start with each user's
+// first upload after the previous window ended.
+type memoryQuotaStore struct {
+	mu     sync.Mutex
+	window time.Duration
+	limits *quotaLimits
+	users  map[string]*quotaUsage
+}
+
+func newMemoryQuotaStore(window time.Duration, limits *quotaLimits) *memoryQuotaStore {
+	return &memoryQuotaStore{
+		window: window,
+		limits: limits,
+		users:  make(map[string]*quotaUsage),
+	}
+}
+
+// current returns user's usage for the window in effect, starting a new one
+// when the last has ended. It must be called with mu held.
+func (s *memoryQuotaStore) current(user string) *quotaUsage {
+	limit, _ := s.limits.limit(user)
+	usage, ok := s.users[user]
+	if !ok || !time.Now().Before(usage.ResetAt) {
+		usage = &quotaUsage{ResetAt: time.Now().Add(s.window)}
+		s.users[user] = usage
+	}
+	usage.Limit = limit
+	return usage
+}
+
+func (s *memoryQuotaStore) usage(user string) quotaUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.current(user)
+}
+
+func (s *memoryQuotaStore) reserve(user string, n int64) (quotaUsage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := s.current(user)
+	if usage.Used+n > usage.Limit {
+		return *usage, false
+	}
+	usage.Used += n
+	return *usage, true
+}
+
+func (s *memoryQuotaStore) add(user string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := s.current(user)
+	usage.Used = max(usage.Used+n, 0)
+}
+
+// newQuotaLimits validates the tier configuration. It returns nil when no
+// tiers are configured.
+func newQuotaLimits(tiers map[string]int64, userTiers map[string]string, window time.Duration) (*quotaLimits, error) {
+	if len(tiers) == 0 {
+		if len(userTiers) > 0 {
+			return nil, fmt.Errorf("USER_TIERS is set but QUOTA_TIERS is empty")
+		}
+		return nil, nil
+	}
+	for tier, limit := range tiers {
+		if limit <= 0 {
+			return nil, fmt.Errorf("quota for tier %q must be positive", tier)
+		}
+	}
+	for user, tier := range userTiers {
+		if _, ok := tiers[tier]; !ok {
+			return nil, fmt.Errorf("user %q has unknown tier %q", user, tier)
+		}
+	}
+	q := &quotaLimits{tiers: tiers, userTiers: userTiers}
+	q.store = newMemoryQuotaStore(window, q)
+	return q, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withQuotas configures the "default" tier at 100 bytes and "pro" at 1000,
+// with bob on pro, and gives alice and bob user tokens of their own name.
+func withQuotas(t *testing.T) *quotaLimits {
+	limits, err := newQuotaLimits(map[string]int64{"default": 100, "pro": 1000}, map[string]string{"bob": "pro"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setGlobal(t, &quotas, limits)
+	setGlobal(t, &userTokens, map[string]string{"alice": "alice", "bob": "bob"})
+	return limits
+}
+
+// serveAs is serve with user's bearer token.
+func serveAs(t *testing.T, user, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Authorization", "Bearer "+user)
+	rec := httptest.NewRecorder()
+	routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestQuotaLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		tiers     map[string]int64
+		userTiers map[string]string
+		user      string
+		limit     int64
+		limited   bool
+	}{
+		{"default tier", map[string]int64{"default": 100, "pro": 1000}, map[string]string{"bob": "pro"}, "alice", 100, true},
+		{"own tier", map[string]int64{"default": 100, "pro": 1000}, map[string]string{"bob": "pro"}, "bob", 1000, true},
+		{"no default tier", map[string]int64{"pro": 1000}, map[string]string{"bob": "pro"}, "alice", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := newQuotaLimits(tt.tiers, tt.userTiers, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limit, limited := q.limit(tt.user)
+			if limit != tt.limit || limited != tt.limited {
+				t.Errorf("limit(%q) = %d, %v; want %d, %v", tt.user, limit, limited, tt.limit, tt.limited)
+			}
+		})
+	}
+}
+
+func TestMemoryQuotaStore(t *testing.T) {
+	type step struct {
+		op   string // reserve, add or expire, which ends the window
+		n    int64
+		used int64
+		ok   bool
+	}
+	tests := []struct {
+		name  string
+		user  string
+		limit int64
+		steps []step
+	}{
+		{"reserve within the limit", "alice", 100, []step{
+			{"reserve", 60, 60, true},
+			{"reserve", 40, 100, true},
+		}},
+		{"reserve past the limit", "alice", 100, []step{
+			{"reserve", 60, 60, true},
+			{"reserve", 41, 60, false},
+		}},
+		{"limit from the user's tier", "bob", 1000, []step{
+			{"reserve", 600, 600, true},
+			{"reserve", 401, 600, false},
+		}},
+		{"add ignores the limit", "alice", 100, []step{
+			{"add", 150, 150, true},
+			{"reserve", 1, 150, false},
+		}},
+		{"refund", "alice", 100, []step{
+			{"reserve", 100, 100, true},
+			{"add", -30, 70, true},
+			{"reserve", 30, 100, true},
+		}},
+		{"refund never goes negative", "alice", 100, []step{
+			{"reserve", 10, 10, true},
+			{"add", -50, 0, true},
+		}},
+		{"window reset", "alice", 100, []step{
+			{"reserve", 100, 100, true},
+			{"expire", 0, 0, true},
+			{"reserve", 100, 100, true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := withQuotas(t).store.(*memoryQuotaStore)
+			for i, s := range tt.steps {
+				ok := true
+				switch s.op {
+				case "reserve":
+					_, ok = store.reserve(tt.user, s.n)
+				case "add":
+					store.add(tt.user, s.n)
+				case "expire":
+					store.users[tt.user].ResetAt = time.Now().Add(-time.Second)
+				}
+				store.mu.Lock()
+				usage := *store.current(tt.user)
+				store.mu.Unlock()
+				if ok != s.ok || usage.Used != s.used || usage.Limit != tt.limit {
+					t.Fatalf("step %d, %s %d: ok %v, used %d of %d; want %v, %d of %d", i+1, s.op, s.n, ok, usage.Used, usage.Limit, s.ok, s.used, tt.limit)
+				}
+				if !usage.ResetAt.After(time.Now()) {
+					t.Fatalf("step %d: window reset at %s, already past", i+1, usage.ResetAt)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		used   int64
+		status int
+		after  int64
+	}{
+		{"generate within quota", http.MethodGet, "/generate?filename=a.txt&exactSize=50", "", 40, http.StatusOK, 90},
+		{"generate past quota", http.MethodGet, "/generate?filename=a.txt&exactSize=50", "", 60, http.StatusTooManyRequests, 60},
+		{"generate with quota used up", http.MethodGet, "/generate?filename=a.txt&exactSize=1", "", 100, http.StatusTooManyRequests, 100},
+		{"upload within quota", http.MethodPost, "/upload?filename=a.txt", strings.Repeat("x", 50), 40, http.StatusOK, 90},
+		{"upload past quota", http.MethodPost, "/upload?filename=a.txt", strings.Repeat("x", 50), 60, http.StatusTooManyRequests, 60},
+		{"upload with quota used up", http.MethodPost, "/upload?filename=a.txt", "x", 100, http.StatusTooManyRequests, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operations := uploadS3(t)
+			limits := withQuotas(t)
+			limits.store.add("alice", tt.used)
+
+			rec := serveAs(t, "alice", tt.method, tt.target, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusTooManyRequests {
+				if rec.Header().Get("Retry-After") == "" {
+					t.Error("429 without Retry-After")
+				}
+				if got := operations(); len(got) != 0 {
+					t.Errorf("S3 calls = %v, want none", got)
+				}
+			}
+			if used := limits.store.usage("alice").Used; used != tt.after {
+				t.Errorf("quota used = %d, want %d", used, tt.after)
+			}
+		})
+	}
+}
+
+// An upload S3 refuses gives back the quota it reserved.
+func TestUploadRefundsQuota(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int64
+	}{
+		{"PutObject", 64 << 20},
+		{"multipart", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>`))
+			})
+			setGlobal(t, &uploader, newUploader(s3Client, 5<<20, 2))
+			setGlobal(t, &multipartThreshold, tt.threshold)
+			limits := withQuotas(t)
+			limits.store.add("alice", 40)
+
+			rec := serveAs(t, "alice", http.MethodPost, "/upload?filename=a.txt", strings.Repeat("x", 50))
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want 500; body %q", rec.Code, rec.Body)
+			}
+			if used := limits.store.usage("alice").Used; used != 40 {
+				t.Errorf("quota used = %d after a failed upload, want 40", used)
+			}
+		})
+	}
+}
+
+// A chunked body's size is unknown until it has been read, so it is capped
+// at the quota left rather than charged whatever it turns out to be.
+func TestUploadChunkedQuota(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		status int
+		after  int64
+	}{
+		{"within quota", 50, http.StatusOK, 90},
+		{"all the quota left", 60, http.StatusOK, 100},
+		{"past quota", 70, http.StatusTooManyRequests, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadS3(t)
+			limits := withQuotas(t)
+			limits.store.add("alice", 40)
+
+			req := httptest.NewRequest(http.MethodPost, "/upload?filename=a.txt", strings.NewReader(strings.Repeat("x", tt.size)))
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Authorization", "Bearer alice")
+			rec := httptest.NewRecorder()
+			routes().ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+			if used := limits.store.usage("alice").Used; used != tt.after {
+				t.Errorf("quota used = %d, want %d", used, tt.after)
+			}
+		})
+	}
+}
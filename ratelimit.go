@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// proxyRateLimit caps, in bytes per second, how fast a single /upload or
+// /download/stream transfer moves data. Zero means unlimited.
+var proxyRateLimit int
+
+// rateLimitedReader paces reads through a token bucket holding one second's
+// worth of bytes, so a transfer can burst briefly but averages the limit.
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// throttle wraps r in its own limiter when PROXY_RATE_LIMIT_BYTES_PER_SEC is
+// set. ctx ends the wait when the client goes away.
+func throttle(ctx context.Context, r io.Reader) io.Reader {
+	if proxyRateLimit <= 0 {
+		return r
+	}
+	return &rateLimitedReader{
+		ctx:     ctx,
+		r:       r,
+		limiter: rate.NewLimiter(rate.Limit(proxyRateLimit), proxyRateLimit),
+	}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// WaitN fails outright for more than the burst, so read at most that
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
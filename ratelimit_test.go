@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		size    int
+		minTime time.Duration
+	}{
+		{"unlimited", 0, 100 << 10, 0},
+		{"within the burst", 64 << 10, 32 << 10, 0},
+		// The first second's worth bursts, the rest is paced
+		{"paced past the burst", 64 << 10, 96 << 10, 400 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &proxyRateLimit, tt.limit)
+			data := bytes.Repeat([]byte("x"), tt.size)
+			r := throttle(context.Background(), bytes.NewReader(data))
+			if tt.limit == 0 {
+				if _, ok := r.(*bytes.Reader); !ok {
+					t.Errorf("throttle wrapped the reader without a limit")
+				}
+			}
+
+			start := time.Now()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("read %d bytes, want %d intact", len(got), len(data))
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("read in %s, want at least %s", elapsed, tt.minTime)
+			}
+		})
+	}
+}
+
+func TestThrottleCancelled(t *testing.T) {
+	setGlobal(t, &proxyRateLimit, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := io.ReadAll(throttle(ctx, strings.NewReader(strings.Repeat("x", 64))))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// readyProbeTimeout bounds the HeadBucket behind /readyz so a hung S3 reads
+// as not ready rather than timing out the orchestrator's probe.
+const readyProbeTimeout = 5 * time.Second
+
+// readinessCheck remembers the last successful HeadBucket for ttl, so
+// frequent probes don't each cost an S3 call. A failure is never cached: the
+// next probe tries again.
+type readinessCheck struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	okTill time.Time
+}
+
+var readiness *readinessCheck
+
+func (c *readinessCheck) check(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.okTill) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readyProbeTimeout)
+	defer cancel()
+	// HeadBucket is signed, so this fails on bad credentials as well as on
+	// an unreachable or missing bucket
+	if _, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	}); err != nil {
+		return err
+	}
+	c.okTill = time.Now().Add(c.ttl)
+	return nil
+}
+
+// handleReady answers 200 once S3 accepts our credentials for the bucket, and
+// 503 until then or while in maintenance mode.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if maintenance.Load() {
+		http.Error(w, "Not ready: in maintenance mode", http.StatusServiceUnavailable)
+		return
+	}
+	if err := readiness.check(r.Context()); err != nil {
+		log.Printf("Readiness check failed: %v", err)
+		http.Error(w, fmt.Sprintf("Not ready: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
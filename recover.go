@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanics turns a panicking handler into a 500 with a JSON error, and
+// logs the panic with its stack trace, instead of leaving net/http to drop
+// the connection. http.ErrAbortHandler is passed on, since panicking with it
+// is how a handler asks for exactly that.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracked := &headerTracker{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			panics.Inc()
+			slog.Error("panic serving request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", v,
+				"stack", string(debug.Stack()),
+			)
+			// Once the handler has started its response it is too late
+			// to replace it, so cut the connection rather than let a
+			// truncated body pass for a complete one
+			if tracked.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "internal server error",
+			})
+		}()
+		next.ServeHTTP(tracked, r)
+	})
+}
+
+// headerTracker records whether a response has been started.
+type headerTracker struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (t *headerTracker) WriteHeader(status int) {
+	t.wroteHeader = true
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *headerTracker) Write(b []byte) (int, error) {
+	t.wroteHeader = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (t *headerTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	// The stack traces would bury the test output
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		status      int
+		wantPanic   any
+		wantCounted bool
+	}{
+		{"no panic", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, nil, false},
+		{"panic before responding", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, http.StatusInternalServerError, nil, true},
+		{"panic after responding", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic("boom")
+		}, http.StatusOK, http.ErrAbortHandler, true},
+		{"ErrAbortHandler passes through", func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) }, http.StatusOK, http.ErrAbortHandler, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(panics)
+			rec := httptest.NewRecorder()
+			func() {
+				defer func() {
+					if got := recover(); got != tt.wantPanic {
+						t.Errorf("panicked with %v, want %v", got, tt.wantPanic)
+					}
+				}()
+				recoverPanics(tt.handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			}()
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusInternalServerError {
+				var body map[string]string
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+					t.Errorf("body = %q, want a JSON error", rec.Body)
+				}
+			}
+			if counted := testutil.ToFloat64(panics) > before; counted != tt.wantCounted {
+				t.Errorf("panic counted = %v, want %v", counted, tt.wantCounted)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// regionDiscoveryTimeout bounds the startup GetBucketLocation call.
+const regionDiscoveryTimeout = 10 * time.Second
+
+// bucketRegions maps buckets kept outside AWS_REGION to their own region,
+// from BUCKET_REGIONS. S3 refuses URLs signed for any region but the
+// bucket's, so these buckets need their own presign client.
+var bucketRegions map[string]string
+
+// presigners hands out the presign client for a bucket's region;
+// presignClient is the one for AWS_BUCKET_NAME.
+var presigners *regionPresigners
+
+var regionNamePattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+// regionPresigners builds a presign client per region on first use and
+// keeps it, so every bucket in a region shares one client.
+type regionPresigners struct {
+	base *s3.Client
+	skew time.Duration
+
+	mu      sync.Mutex
+	clients map[string]presigner
+}
+
+func newRegionPresigners(base *s3.Client, skew time.Duration) *regionPresigners {
+	return &regionPresigners{base: base, skew: skew, clients: make(map[string]presigner)}
+}
+
+// forBucket returns the presigner for name's region: the one from
+// bucketRegions, or the base client's when name isn't mapped.
+func (p *regionPresigners) forBucket(name string) presigner {
+	region := p.base.Options().Region
+	if mapped := bucketRegions[name]; mapped != "" {
+		region = mapped
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.clients[region]; ok {
+		return c
+	}
+	client := p.base
+	if region != client.Options().Region {
+		client = s3.New(client.Options(), func(o *s3.Options) {
+			o.Region = region
+		})
+	}
+	c := newPresignClient(client, p.skew)
+	p.clients[region] = c
+	return c
+}
+
+// discoverRegion finds the bucket's region for when AWS_REGION is unset. An
+// access point names its region in its ARN; for a bucket we ask
+// GetBucketLocation, which us-east-1 answers for buckets in any region.
+func discoverRegion(ctx context.Context, awsCfg aws.Config, accessPoint *arn.ARN) (string, error) {
+	if accessPoint != nil {
+		return accessPoint.Region, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, regionDiscoveryTimeout)
+	defer cancel()
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.Region = "us-east-1"
+	})
+	return bucketLocation(ctx, client, bucket)
+}
+
+// bucketLocation reads a bucket's region from GetBucketLocation, which
+// reports us-east-1 as no constraint and eu-west-1 as the legacy "EU".
+func bucketLocation(ctx context.Context, client *s3.Client, name string) (string, error) {
+	resp, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+		Bucket: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	switch resp.LocationConstraint {
+	case "":
+		return "us-east-1", nil
+	case "EU":
+		return "eu-west-1", nil
+	default:
+		return string(resp.LocationConstraint), nil
+	}
+}
+
+// checkBucketRegions confirms each BUCKET_REGIONS entry against
+// GetBucketLocation, so a wrong region fails startup rather than every URL
+// for that bucket. A bucket whose location can't be read is trusted, with a
+// warning.
+func checkBucketRegions(ctx context.Context, awsCfg aws.Config) error {
+	// GetBucketLocation answers for buckets in any region from us-east-1
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.Region = "us-east-1"
+	})
+	names := make([]string, 0, len(bucketRegions))
+	for name := range bucketRegions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		actual, err := bucketLocation(ctx, client, name)
+		if err != nil {
+			log.Printf("Warning: unable to check the region of bucket %s: %v", name, err)
+			continue
+		}
+		if actual != bucketRegions[name] {
+			return fmt.Errorf("bucket %s is in %s, not %s", name, actual, bucketRegions[name])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// redirectTransport sends every request to target, whatever host it names.
+type redirectTransport struct{ target *url.URL }
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// locationConfig returns an aws.Config whose GetBucketLocation answers with
+// constraint, or fails when status isn't 200.
+func locationConfig(t *testing.T, status int, constraint string) aws.Config {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`<LocationConstraint>` + constraint + `</LocationConstraint>`))
+	}))
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	return aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AK", "SK", ""),
+		HTTPClient:  &http.Client{Transport: redirectTransport{target}},
+	}
+}
+
+func TestCheckBucketRegions(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		constraint string
+		mapped     string
+		wantErr    bool
+	}{
+		{"matches", http.StatusOK, "eu-west-2", "eu-west-2", false},
+		{"us-east-1 has no constraint", http.StatusOK, "", "us-east-1", false},
+		{"legacy EU", http.StatusOK, "EU", "eu-west-1", false},
+		{"elsewhere", http.StatusOK, "ap-southeast-2", "eu-west-2", true},
+		{"unreadable location is trusted", http.StatusForbidden, "", "eu-west-2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &bucketRegions, map[string]string{"photos": tt.mapped})
+			err := checkBucketRegions(context.Background(), locationConfig(t, tt.status, tt.constraint))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkBucketRegions with photos in %s = %v, want error %v", tt.mapped, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateBucketRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		regions map[string]string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"regions", map[string]string{"photos": "eu-west-2", "logs": "us-gov-west-1"}, false},
+		{"not a region", map[string]string{"photos": "europe"}, true},
+		{"empty region", map[string]string{"photos": ""}, true},
+		{"access point", map[string]string{"arn:aws:s3:eu-west-2:123456789012:accesspoint/ap": "eu-west-2"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := defaultConfig()
+			conf.Region, conf.Bucket = "us-east-1", "photos"
+			conf.BucketRegions = tt.regions
+			if err := conf.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A bucket outside AWS_REGION must be presigned with its own region in the
+// credential scope, or S3 refuses the URL.
+func TestPresignCrossRegionBucket(t *testing.T) {
+	setGlobal(t, &bucketRegions, map[string]string{"photos": "eu-west-2"})
+	base := s3.NewFromConfig(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AK", "SK", ""),
+	})
+	presigners := newRegionPresigners(base, 0)
+
+	tests := []struct {
+		bucket string
+		region string
+	}{
+		{"photos", "eu-west-2"},
+		{"assets", "us-east-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.bucket, func(t *testing.T) {
+			req, err := presigners.forBucket(tt.bucket).PresignGetObject(context.Background(), &s3.GetObjectInput{
+				Bucket: aws.String(tt.bucket),
+				Key:    aws.String(keyPrefix + "a.png"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(req.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if credential := u.Query().Get("X-Amz-Credential"); !strings.Contains(credential, "/"+tt.region+"/s3/") {
+				t.Errorf("X-Amz-Credential = %q, want the %s scope", credential, tt.region)
+			}
+			if want := tt.bucket + ".s3." + tt.region + ".amazonaws.com"; u.Host != want {
+				t.Errorf("host = %q, want %q", u.Host, want)
+			}
+			if err := validatePresignedURL(req.URL, keyPrefix+"a.png"); err != nil {
+				t.Errorf("validatePresignedURL: %v", err)
+			}
+		})
+	}
+
+	if presigners.forBucket("photos") != presigners.forBucket("photos") {
+		t.Error("forBucket built a second client for the same region")
+	}
+	if n := len(presigners.clients); n != 2 {
+		t.Errorf("%d clients cached, want one per region", n)
+	}
+}
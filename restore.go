@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Defaults for /restore when the request doesn't pick its own.
+var restoreTier types.Tier
+var restoreDays int32
+
+func parseRestoreTier(v string) (types.Tier, error) {
+	for _, tier := range types.Tier("").Values() {
+		if string(tier) == v {
+			return tier, nil
+		}
+	}
+	return "", fmt.Errorf("tier must be one of Expedited, Standard or Bulk")
+}
+
+func parseRestoreDays(v string) (int32, error) {
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 1 || days > 30000 {
+		return 0, fmt.Errorf("days must be between 1 and 30000")
+	}
+	return int32(days), nil
+}
+
+// handleRestore asks S3 to bring an archived object back from Glacier for a
+// number of days. The response status mirrors S3: 202 while a restore is
+// running, 200 when a restored copy is already available.
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filename := query.Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	filename = userFilename(r, filename)
+
+	tier := restoreTier
+	if v := query.Get("tier"); v != "" {
+		var err error
+		if tier, err = parseRestoreTier(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	days := restoreDays
+	if v := query.Get("days"); v != "" {
+		var err error
+		if days, err = parseRestoreDays(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := keyPrefix + filename
+	resp, err := s3Client.RestoreObject(r.Context(), &s3.RestoreObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &types.RestoreRequest{
+			Days: aws.Int32(days),
+			GlacierJobParameters: &types.GlacierJobParameters{
+				Tier: tier,
+			},
+		},
+	})
+
+	status, state := http.StatusAccepted, "inProgress"
+	var apiErr smithy.APIError
+	var noSuchKey *types.NoSuchKey
+	switch {
+	case err == nil:
+		// S3 answers 200 rather than 202 when the object is already restored
+		if raw, ok := awsmiddleware.GetRawResponse(resp.ResultMetadata).(*smithyhttp.Response); ok && raw.StatusCode == http.StatusOK {
+			status, state = http.StatusOK, "restored"
+		}
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress":
+	case errors.As(err, &noSuchKey) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey"):
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState":
+		http.Error(w, "Object is not archived", http.StatusConflict)
+		return
+	default:
+		log.Printf("Error restoring object: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to restore object: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"key":    key,
+		"status": state,
+		"tier":   string(tier),
+	})
+}
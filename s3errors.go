@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// throttleRetryAfter is the Retry-After, in seconds, sent when S3 throttles us.
+const throttleRetryAfter = "5"
+
+var throttleCodes = map[string]bool{
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestThrottled":         true,
+	"RequestLimitExceeded":     true,
+	"TooManyRequestsException": true,
+	"ServiceUnavailable":       true,
+}
+
+// isThrottled reports whether err is S3 asking us to slow down, either by
+// error code or by a bare 503.
+func isThrottled(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && throttleCodes[apiErr.ErrorCode()] {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusServiceUnavailable
+}
+
+// hasErrorCode reports whether err is an S3 error with the given code. Some
+// operations don't model the errors they can return, NoSuchUpload from
+// CompleteMultipartUpload among them, so matching the typed error misses them.
+func hasErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
+
+// respondThrottled answers with 503 and a Retry-After when err is throttling,
+// so clients back off instead of treating it as a server fault. Calls the
+// circuit breaker refused get the same treatment. It reports whether it wrote
+// a response.
+func respondThrottled(w http.ResponseWriter, err error) bool {
+	if respondBreakerOpen(w, err) {
+		return true
+	}
+	if !isThrottled(err) {
+		return false
+	}
+	w.Header().Set("Retry-After", throttleRetryAfter)
+	http.Error(w, "S3 is throttling requests, retry later", http.StatusServiceUnavailable)
+	return true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func responseError(status int) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      errors.New("failed"),
+		},
+	}
+}
+
+func TestRespondThrottled(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"SlowDown", &smithy.GenericAPIError{Code: "SlowDown"}, true},
+		{"wrapped ThrottlingException", fmt.Errorf("op: %w", &smithy.GenericAPIError{Code: "ThrottlingException"}), true},
+		{"bare 503", responseError(http.StatusServiceUnavailable), true},
+		{"AccessDenied", &smithy.GenericAPIError{Code: "AccessDenied"}, false},
+		{"500", responseError(http.StatusInternalServerError), false},
+		{"not from S3", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if got := respondThrottled(rec, tt.err); got != tt.want {
+				t.Fatalf("respondThrottled(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+			if !tt.want {
+				if rec.Code != http.StatusOK || rec.Body.Len() > 0 {
+					t.Errorf("wrote %d %q, want nothing", rec.Code, rec.Body)
+				}
+				return
+			}
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want 503", rec.Code)
+			}
+			if got := rec.Header().Get("Retry-After"); got != throttleRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, throttleRetryAfter)
+			}
+		})
+	}
+}
+
+// A throttled S3 call surfaces from a handler as a 503 with Retry-After
+// rather than a 500.
+func TestDownloadStreamThrottled(t *testing.T) {
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`))
+	})
+	rec := serve(t, http.MethodGet, "/download/stream?filename=a.txt", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503; body %q", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != throttleRetryAfter {
+		t.Errorf("Retry-After = %q, want %q", got, throttleRetryAfter)
+	}
+}
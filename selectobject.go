@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var selectOutputTypes = map[string]string{
+	"csv":  "text/csv",
+	"json": "application/x-ndjson",
+}
+
+// handleSelect runs an S3 Select query against an uploaded CSV or JSON object
+// and streams the matching records back as S3 produces them.
+func handleSelect(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Filename     string `json:"filename"`
+		Expression   string `json:"expression"`
+		InputFormat  string `json:"inputFormat"`
+		OutputFormat string `json:"outputFormat"`
+		// CSVHeader is USE, IGNORE or NONE, defaulting to USE
+		CSVHeader string `json:"csvHeader"`
+		// JSONType is DOCUMENT or LINES, defaulting to LINES
+		JSONType string `json:"jsonType"`
+	}
+
+	var errs validationErrors
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		errs.add("body", fmt.Sprintf("invalid JSON: %v", err))
+		errs.respond(w)
+		return
+	}
+	if payload.Filename == "" {
+		errs.add("filename", "is required")
+	}
+	if payload.Expression == "" {
+		errs.add("expression", "is required")
+	}
+
+	input := &types.InputSerialization{}
+	switch payload.InputFormat {
+	case "csv":
+		header := types.FileHeaderInfoUse
+		if payload.CSVHeader != "" {
+			header = types.FileHeaderInfo(payload.CSVHeader)
+		}
+		switch header {
+		case types.FileHeaderInfoUse, types.FileHeaderInfoIgnore, types.FileHeaderInfoNone:
+		default:
+			errs.add("csvHeader", "must be USE, IGNORE or NONE")
+		}
+		input.CSV = &types.CSVInput{FileHeaderInfo: header}
+	case "json":
+		jsonType := types.JSONTypeLines
+		if payload.JSONType != "" {
+			jsonType = types.JSONType(payload.JSONType)
+		}
+		if jsonType != types.JSONTypeDocument && jsonType != types.JSONTypeLines {
+			errs.add("jsonType", "must be DOCUMENT or LINES")
+		}
+		input.JSON = &types.JSONInput{Type: jsonType}
+	default:
+		errs.add("inputFormat", "must be csv or json")
+	}
+
+	outputFormat := payload.OutputFormat
+	if outputFormat == "" {
+		outputFormat = payload.InputFormat
+	}
+	output := &types.OutputSerialization{}
+	switch outputFormat {
+	case "csv":
+		output.CSV = &types.CSVOutput{}
+	case "json":
+		output.JSON = &types.JSONOutput{}
+	default:
+		errs.add("outputFormat", "must be csv or json")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	resp, err := s3Client.SelectObjectContent(r.Context(), &s3.SelectObjectContentInput{
+		Bucket:              aws.String(bucket),
+		Key:                 aws.String(keyPrefix + userFilename(r, payload.Filename)),
+		Expression:          aws.String(payload.Expression),
+		ExpressionType:      types.ExpressionTypeSql,
+		InputSerialization:  input,
+		OutputSerialization: output,
+	})
+	if hasErrorCode(err, "NoSuchKey") {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error selecting object content: %v", err)
+		if respondThrottled(w, err) {
+			return
+		}
+		// Bad SQL and objects that don't parse as the given format come back
+		// as 400s, which are the client's to fix
+		status := http.StatusInternalServerError
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusBadRequest {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, fmt.Sprintf("Failed to select object content: %v", err), status)
+		return
+	}
+	stream := resp.GetStream()
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", selectOutputTypes[outputFormat])
+	flush := http.NewResponseController(w).Flush
+	for event := range stream.Events() {
+		records, ok := event.(*types.SelectObjectContentEventStreamMemberRecords)
+		if !ok {
+			continue
+		}
+		if _, err := w.Write(records.Value.Payload); err != nil {
+			log.Printf("Error streaming selected records: %v", err)
+			return
+		}
+		flush()
+	}
+	// The status is already sent, so a failure mid-stream can only be logged
+	if err := stream.Err(); err != nil {
+		log.Printf("Error streaming selected records: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// selfTestTimeout bounds each self-test step.
+const selfTestTimeout = 30 * time.Second
+
+// runSelfTest writes, reads back and deletes a throwaway object under
+// keyPrefix, logging the outcome and latency of each step, and reports
+// whether all of them passed. It exercises the same permissions serving
+// traffic needs, which makes it a deploy-time check of IAM policy and
+// bucket settings. The object is deleted even when a step fails.
+func runSelfTest(ctx context.Context) bool {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	key := keyPrefix + ".selftest/" + hex.EncodeToString(suffix)
+	payload := []byte("s3-image self-test " + time.Now().UTC().Format(time.RFC3339))
+
+	passed := true
+	step := func(name string, fn func(ctx context.Context) error) bool {
+		ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		defer cancel()
+		start := time.Now()
+		err := fn(ctx)
+		if err != nil {
+			passed = false
+			log.Printf("Self-test FAIL %-8s %s: %v", name, time.Since(start).Round(time.Millisecond), err)
+			return false
+		}
+		log.Printf("Self-test PASS %-8s %s", name, time.Since(start).Round(time.Millisecond))
+		return true
+	}
+
+	log.Printf("Self-test using key %s in bucket %s", key, bucket)
+	uploaded := step("upload", func(ctx context.Context) error {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:           aws.String(bucket),
+			Key:              aws.String(key),
+			Body:             bytes.NewReader(payload),
+			ContentType:      aws.String("text/plain"),
+			BucketKeyEnabled: bucketKeyEnabled(),
+		})
+		return err
+	})
+	if uploaded {
+		step("download", func(ctx context.Context) error {
+			resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, payload) {
+				return fmt.Errorf("read back %d bytes that differ from the %d written", len(got), len(payload))
+			}
+			return nil
+		})
+	}
+	// Clean up whatever happened above; a failed upload may still have
+	// left an object behind if only its response was lost
+	step("delete", func(ctx context.Context) error {
+		_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		return err
+	})
+
+	if passed {
+		log.Println("Self-test passed")
+	} else {
+		log.Println("Self-test failed")
+	}
+	return passed
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSizeByExtension caps the declared upload size per file extension. The
+// "*" entry, when present, applies to extensions without their own entry.
+var maxSizeByExtension map[string]int64
+
+// loadMaxSizeByExtension validates the configured extension -> max bytes
+// mapping, reading it from path as JSON when it isn't set inline.
+func loadMaxSizeByExtension(parsed map[string]int64, path string) (map[string]int64, error) {
+	if len(parsed) == 0 && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			return nil, err
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, nil
+	}
+
+	limits := make(map[string]int64, len(parsed))
+	for ext, size := range parsed {
+		if size <= 0 {
+			return nil, fmt.Errorf("max size for %q must be positive", ext)
+		}
+		limits[normalizeExt(ext)] = size
+	}
+	return limits, nil
+}
+
+func normalizeExt(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+// maxSizeFor returns the size cap for filename and whether one applies.
+func maxSizeFor(filename string) (int64, bool) {
+	if size, ok := maxSizeByExtension[normalizeExt(filepath.Ext(filename))]; ok {
+		return size, true
+	}
+	size, ok := maxSizeByExtension["*"]
+	return size, ok
+}
@@ -0,0 +1,18 @@
+package main
+
+import "github.com/aws/aws-sdk-go-v2/aws"
+
+// sseBucketKey, set by SSE_BUCKET_KEY, asks S3 to use an S3 Bucket Key for
+// the objects we write, cutting the number of KMS calls SSE-KMS makes. It
+// only has an effect when the object ends up encrypted with SSE-KMS, which
+// here means through the bucket's default encryption.
+var sseBucketKey bool
+
+// bucketKeyEnabled returns the BucketKeyEnabled value for object writes, nil
+// when the option is off so the bucket's own setting applies.
+func bucketKeyEnabled() *bool {
+	if !sseBucketKey {
+		return nil
+	}
+	return aws.Bool(true)
+}
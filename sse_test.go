@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestBucketKeyEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		on   bool
+		want string
+	}{
+		{"off leaves the bucket's setting", false, ""},
+		{"on", true, "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created http.Header
+			fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+				created = r.Header
+				w.Write([]byte(`<InitiateMultipartUploadResult><Bucket>b</Bucket><Key>k</Key><UploadId>U1</UploadId></InitiateMultipartUploadResult>`))
+			})
+			setGlobal(t, &sseBucketKey, tt.on)
+
+			// Hoisted into the presigned PUT's query, so clients send nothing
+			rec := serve(t, http.MethodGet, "/generate?filename=a.txt", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("/generate status = %d, body %q", rec.Code, rec.Body)
+			}
+			u, err := url.Parse(rec.Body.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := u.Query().Get("X-Amz-Server-Side-Encryption-Bucket-Key-Enabled"); got != tt.want {
+				t.Errorf("presigned PUT bucket key = %q, want %q", got, tt.want)
+			}
+
+			rec = serve(t, http.MethodPost, "/multipart/initiate?key=a.bin", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("/multipart/initiate status = %d, body %q", rec.Code, rec.Body)
+			}
+			if got := created.Get("X-Amz-Server-Side-Encryption-Bucket-Key-Enabled"); got != tt.want {
+				t.Errorf("CreateMultipartUpload bucket key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
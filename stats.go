@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type bucketUsage struct {
+	ObjectCount int64 `json:"objectCount"`
+	TotalBytes  int64 `json:"totalBytes"`
+	// Truncated is set when maxPages was reached before the listing finished,
+	// so the counts are a lower bound.
+	Truncated bool `json:"truncated"`
+}
+
+// statsCache holds the last computed usage, since listing the whole prefix is
+// expensive on large buckets.
+type statsCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxPages   int
+	usage      bucketUsage
+	computedAt time.Time
+}
+
+var bucketStats *statsCache
+
+func newStatsCache(ttl time.Duration, maxPages int) *statsCache {
+	return &statsCache{ttl: ttl, maxPages: maxPages}
+}
+
+func (c *statsCache) get(ctx context.Context) (bucketUsage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.computedAt.IsZero() && time.Since(c.computedAt) < c.ttl {
+		return c.usage, nil
+	}
+
+	usage, err := computeUsage(ctx, c.maxPages)
+	if err != nil {
+		return bucketUsage{}, err
+	}
+	c.usage = usage
+	c.computedAt = time.Now()
+	return usage, nil
+}
+
+func computeUsage(ctx context.Context, maxPages int) (bucketUsage, error) {
+	var usage bucketUsage
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(keyPrefix),
+	})
+
+	for pages := 0; paginator.HasMorePages(); pages++ {
+		if pages == maxPages {
+			usage.Truncated = true
+			break
+		}
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return bucketUsage{}, err
+		}
+		for _, obj := range page.Contents {
+			usage.ObjectCount++
+			usage.TotalBytes += aws.ToInt64(obj.Size)
+		}
+	}
+	return usage, nil
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	usage, err := bucketStats.get(context.TODO())
+	if err != nil {
+		log.Printf("Error computing bucket stats: %v", err)
+		if respondThrottled(w, err) {
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to compute bucket stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
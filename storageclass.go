@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// storageClassRule picks StorageClass for uploads matching all of its
+// conditions. ContentType is a media type pattern such as "image/*" and
+// MinSize and MaxSize bound the declared size in bytes; each is ignored when
+// left empty or zero. A rule with a condition never matches an upload that
+// doesn't declare what the condition tests.
+type storageClassRule struct {
+	ContentType  string `yaml:"contentType" json:"contentType"`
+	MinSize      int64  `yaml:"minSize" json:"minSize"`
+	MaxSize      int64  `yaml:"maxSize" json:"maxSize"`
+	StorageClass string `yaml:"storageClass" json:"storageClass"`
+}
+
+// storageClassRules, from STORAGE_CLASS_RULES, choose the storage class of
+// /generate uploads, the first matching rule winning, so that say small
+// thumbnails stay in STANDARD while large originals go to STANDARD_IA. With
+// no match the bucket's default applies.
+var storageClassRules []storageClassRule
+
+func validateStorageClassRules(rules []storageClassRule) error {
+	for i, rule := range rules {
+		if !slices.Contains(types.StorageClass("").Values(), types.StorageClass(rule.StorageClass)) {
+			return fmt.Errorf("rule %d: unknown storage class %q", i, rule.StorageClass)
+		}
+		if _, err := path.Match(rule.ContentType, ""); err != nil {
+			return fmt.Errorf("rule %d: invalid content type pattern %q", i, rule.ContentType)
+		}
+		if rule.MinSize < 0 || rule.MaxSize < 0 || (rule.MaxSize > 0 && rule.MaxSize < rule.MinSize) {
+			return fmt.Errorf("rule %d: sizes must be non-negative with maxSize at least minSize", i)
+		}
+	}
+	return nil
+}
+
+// storageClassFor returns the storage class of the first rule matching an
+// upload's content type and size, either of which may be unknown, and
+// whether one matched.
+func storageClassFor(contentType *string, size *int64) (types.StorageClass, bool) {
+	for _, rule := range storageClassRules {
+		if rule.ContentType != "" {
+			if contentType == nil {
+				continue
+			}
+			mediaType, _, err := mime.ParseMediaType(*contentType)
+			if err != nil {
+				continue
+			}
+			if ok, _ := path.Match(rule.ContentType, mediaType); !ok {
+				continue
+			}
+		}
+		if rule.MinSize > 0 || rule.MaxSize > 0 {
+			if size == nil || *size < rule.MinSize || (rule.MaxSize > 0 && *size > rule.MaxSize) {
+				continue
+			}
+		}
+		return types.StorageClass(rule.StorageClass), true
+	}
+	return "", false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+)
+
+// uploadCategories maps each category in UPLOAD_CATEGORIES to the tag set it
+// stands for, merged with DEFAULT_TAGS and already encoded for the Tagging
+// field. Uploads without a category are tagged with the defaults alone, kept
+// under the empty category. Keeping the tags here rather than letting clients
+// send their own means lifecycle rules keyed on them can rely on every object
+// in a category being tagged the same way.
+var uploadCategories map[string]string
+
+// S3's limits on object tags.
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// newUploadCategories merges defaults into each category's tags, checks the
+// results against S3's limits and encodes them. Every tag set an upload can
+// get is known here, so a combination S3 would refuse fails at startup rather
+// than as a rejected PUT.
+func newUploadCategories(categories map[string]map[string]string, defaults map[string]string) (map[string]string, error) {
+	if len(defaults) > maxObjectTags {
+		return nil, fmt.Errorf("DEFAULT_TAGS must have at most %d tags", maxObjectTags)
+	}
+	if err := checkTags(defaults); err != nil {
+		return nil, fmt.Errorf("DEFAULT_TAGS %w", err)
+	}
+
+	encoded := make(map[string]string, len(categories)+1)
+	if len(defaults) > 0 {
+		encoded[""] = encodeTags(defaults)
+	}
+	for category, tags := range categories {
+		if category == "" {
+			return nil, fmt.Errorf("category names must not be empty")
+		}
+		if err := checkTags(tags); err != nil {
+			return nil, fmt.Errorf("category %q %w", category, err)
+		}
+		merged := maps.Clone(defaults)
+		if merged == nil {
+			merged = make(map[string]string, len(tags))
+		}
+		maps.Copy(merged, tags)
+		if len(tags) == 0 || len(merged) > maxObjectTags {
+			return nil, fmt.Errorf("category %q must have between 1 and %d tags including DEFAULT_TAGS, has %d", category, maxObjectTags, len(merged))
+		}
+		encoded[category] = encodeTags(merged)
+	}
+	return encoded, nil
+}
+
+// checkTags checks each key and value against S3's length limits.
+func checkTags(tags map[string]string) error {
+	for k, v := range tags {
+		if k == "" || len(k) > maxTagKeyLength {
+			return fmt.Errorf("has a tag key that is empty or longer than %d characters", maxTagKeyLength)
+		}
+		if len(v) > maxTagValueLength {
+			return fmt.Errorf("tag %q is longer than %d characters", k, maxTagValueLength)
+		}
+	}
+	return nil
+}
+
+func encodeTags(tags map[string]string) string {
+	values := make(url.Values, len(tags))
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// categoryTagging returns the Tagging value for the "category" query
+// parameter, nil when there is neither a category nor DEFAULT_TAGS.
+func categoryTagging(query url.Values) (*string, error) {
+	category := query.Get("category")
+	tagging, ok := uploadCategories[category]
+	if !ok {
+		if category == "" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unknown category %q", category)
+	}
+	return &tagging, nil
+}
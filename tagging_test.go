@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// tags returns n distinct tags.
+func tags(n int) map[string]string {
+	t := make(map[string]string, n)
+	for i := range n {
+		t[fmt.Sprintf("k%d", i)] = "v"
+	}
+	return t
+}
+
+func TestNewUploadCategories(t *testing.T) {
+	tests := []struct {
+		name       string
+		categories map[string]map[string]string
+		defaults   map[string]string
+		want       map[string]string
+		wantErr    bool
+	}{
+		{"nothing", nil, nil, map[string]string{}, false},
+		{"categories only", map[string]map[string]string{"avatar": {"retention": "long"}}, nil, map[string]string{"avatar": "retention=long"}, false},
+		{"defaults only", nil, map[string]string{"app": "uploader"}, map[string]string{"": "app=uploader"}, false},
+		{
+			"categories override defaults",
+			map[string]map[string]string{"tmp": {"retention": "short"}},
+			map[string]string{"app": "uploader", "retention": "long"},
+			map[string]string{"": "app=uploader&retention=long", "tmp": "app=uploader&retention=short"},
+			false,
+		},
+		{"values are encoded", map[string]map[string]string{"a": {"team": "data & ml"}}, nil, map[string]string{"a": "team=data+%26+ml"}, false},
+		{"empty category", map[string]map[string]string{"a": {}}, nil, nil, true},
+		{"empty category name", map[string]map[string]string{"": {"k": "v"}}, nil, nil, true},
+		{"a category at the limit", map[string]map[string]string{"a": tags(maxObjectTags)}, nil, nil, false},
+		{"a category over the limit", map[string]map[string]string{"a": tags(maxObjectTags + 1)}, nil, nil, true},
+		{"defaults over the limit", nil, tags(maxObjectTags + 1), nil, true},
+		{"merged over the limit", map[string]map[string]string{"a": {"extra": "v"}}, tags(maxObjectTags), nil, true},
+		{"overlap stays at the limit", map[string]map[string]string{"a": {"k0": "w"}}, tags(maxObjectTags), nil, false},
+		{"key too long", map[string]map[string]string{"a": {strings.Repeat("k", maxTagKeyLength+1): "v"}}, nil, nil, true},
+		{"default value too long", nil, map[string]string{"k": strings.Repeat("v", maxTagValueLength+1)}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newUploadCategories(tt.categories, tt.defaults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newUploadCategories error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.want != nil && !maps.Equal(got, tt.want) {
+				t.Errorf("newUploadCategories = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTagging(t *testing.T) {
+	fakeS3(t, nil)
+	categories, err := newUploadCategories(map[string]map[string]string{"tmp": {"retention": "short"}}, map[string]string{"app": "uploader"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setGlobal(t, &uploadCategories, categories)
+	tests := []struct {
+		name     string
+		category string
+		status   int
+		tagging  string
+	}{
+		{"defaults without a category", "", http.StatusOK, "app=uploader"},
+		{"category", "tmp", http.StatusOK, "app=uploader&retention=short"},
+		{"unknown category", "nope", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"filename": {"a.txt"}}
+			if tt.category != "" {
+				query.Set("category", tt.category)
+			}
+			rec := serve(t, http.MethodGet, "/generate?"+query.Encode(), "")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get("X-Object-Tagging"); got != tt.tagging {
+				t.Errorf("X-Object-Tagging = %q, want %q", got, tt.tagging)
+			}
+		})
+	}
+}
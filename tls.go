@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the server's TLS settings from TLS_MIN_VERSION and
+// TLS_CIPHER_SUITES. Suites are named as in crypto/tls, e.g.
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", and only ones Go considers secure
+// are accepted. They only limit TLS 1.2 and below: Go doesn't let TLS 1.3
+// suites be configured, and all of them are strong. No suites leaves Go's
+// defaults.
+func newTLSConfig(minVersion string, cipherSuites []string) (*tls.Config, error) {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", minVersion)
+	}
+	config := &tls.Config{MinVersion: version}
+	if len(cipherSuites) == 0 {
+		return config, nil
+	}
+
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	for _, name := range cipherSuites {
+		id, ok := secure[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return config, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+	_ "golang.org/x/image/webp"
+)
+
+// variantPrefix holds transcoded copies, keyed by the source ETag so a
+// changed source never serves a stale variant.
+const variantPrefix = keyPrefix + "variants/"
+
+// maxTranscodeSourceSize bounds the source images /transcode will decode.
+var maxTranscodeSourceSize int64
+
+// maxTranscodePixels, like maxCompressPixels, stops a source within
+// maxTranscodeSourceSize declaring dimensions that decode into gigabytes.
+const maxTranscodePixels = 100_000_000
+
+var errTranscodeTooLarge = errors.New("source has too many pixels to transcode")
+
+var transcodeFormats = map[string]string{
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+// transcodeFormat picks the output format from the format parameter, falling
+// back to the best type the Accept header allows.
+func transcodeFormat(r *http.Request) (string, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		_, ok := transcodeFormats[format]
+		return format, ok
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "image/avif"):
+		return "avif", true
+	case strings.Contains(accept, "image/webp"):
+		return "webp", true
+	}
+	return "", false
+}
+
+func variantKey(filename, eTag, format string) string {
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	return variantPrefix + strings.Trim(eTag, `"`) + "/" + base + "." + format
+}
+
+// handleTranscode re-encodes an uploaded image as WebP or AVIF, stores the
+// result next to the source and returns a presigned GET for it. Variants
+// that already exist for the source's current ETag are reused.
+func handleTranscode(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	filename = userFilename(r, filename)
+	format, ok := transcodeFormat(r)
+	if !ok {
+		http.Error(w, "format must be webp or avif", http.StatusNotAcceptable)
+		return
+	}
+	expiry, err := requestedExpiry(r.URL.Query(), downloadExpiry)
+	if err != nil {
+		http.Error(w, "Invalid expires: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sourceKey := keyPrefix + filename
+	source, err := headObject(r.Context(), sourceKey)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking transcode source: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to check transcode source: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	if aws.ToInt64(source.ContentLength) > maxTranscodeSourceSize {
+		http.Error(w, fmt.Sprintf("Source exceeds the %d byte transcode limit", maxTranscodeSourceSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	key := variantKey(filename, aws.ToString(source.ETag), format)
+	_, err = headObject(r.Context(), key)
+	cached := err == nil
+	if !cached && !errors.As(err, &notFound) {
+		log.Printf("Error checking transcoded variant: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to check transcoded variant: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if !cached {
+		err := transcode(r.Context(), sourceKey, aws.ToString(source.ETag), key, format)
+		if errors.Is(err, errTranscodeTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.Printf("Error transcoding %s to %s: %v", sourceKey, format, err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to transcode image: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	req, err := presignClient.PresignGetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, presignExpires(r.Context(), expiry))
+	if err != nil {
+		log.Printf("Error generating presigned variant URL: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to generate presigned variant URL: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := validatePresignedURL(req.URL, key); err != nil {
+		log.Printf("Presigned variant URL failed sanity check: %v", err)
+		http.Error(w, "Failed to generate presigned variant URL", http.StatusInternalServerError)
+		return
+	}
+
+	auditPresign(r, "GetObject", key, req.URL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"key":    key,
+		"format": format,
+		"cached": cached,
+		"url":    req.URL,
+	})
+}
+
+// transcode downloads sourceKey, pinned to eTag so a concurrent overwrite
+// can't end up under the old ETag's variant, and stores it re-encoded at key.
+func transcode(ctx context.Context, sourceKey, eTag, key, format string) error {
+	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(sourceKey),
+		IfMatch: aws.String(eTag),
+	})
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj.Body, maxTranscodeSourceSize))
+	if err != nil {
+		return fmt.Errorf("reading source: %w", err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decoding source: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxTranscodePixels {
+		return fmt.Errorf("%w: %dx%d", errTranscodeTooLarge, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decoding source: %w", err)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "webp":
+		err = webp.Encode(&buf, img)
+	case "avif":
+		err = avif.Encode(&buf, img)
+	}
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", format, err)
+	}
+
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:           aws.String(bucket),
+		Key:              aws.String(key),
+		Body:             bytes.NewReader(buf.Bytes()),
+		ContentLength:    aws.Int64(int64(buf.Len())),
+		ContentType:      aws.String(transcodeFormats[format]),
+		BucketKeyEnabled: bucketKeyEnabled(),
+	})
+	return err
+}
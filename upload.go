@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxPutObjectSize is the largest body S3 accepts in a single PutObject.
+const maxPutObjectSize = 5 << 30
+
+// uploader sends /upload bodies larger than multipartThreshold as a
+// multipart upload.
+var uploader *manager.Uploader
+var multipartThreshold int64
+
+func newUploader(client *s3.Client, partSize int64, concurrency int) *manager.Uploader {
+	return manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = concurrency
+	})
+}
+
+// verifyContentType, set by VERIFY_CONTENT_TYPE, makes /upload check the
+// declared Content-Type against the one sniffed from the body. Only types
+// http.DetectContentType recognises can pass, so it suits image-only buckets
+// better than mixed content.
+var verifyContentType bool
+
+// requireContentLength, set by REQUIRE_CONTENT_LENGTH, makes /generate sign
+// every URL for the size given in its size parameter.
+var requireContentLength bool
+
+// sniffContentType reads the first 512 bytes of body and returns the media
+// type detected in them, along with a reader that still yields the whole body.
+func sniffContentType(body io.Reader) (string, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	head = head[:n]
+
+	sniffed, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "", nil, err
+	}
+	return sniffed, io.MultiReader(bytes.NewReader(head), body), nil
+}
+
+// handleUpload streams the request body to S3 for clients that can't reach
+// S3 directly. The body is handed to PutObject as it arrives rather than
+// being buffered, so the payload is sent unsigned since it can't be hashed up
+// front. PutObject also needs the length in advance, so chunked bodies
+// without a Content-Length always go through the multipart uploader, which
+// doesn't.
+//
+// With compress=true, JPEGs between COMPRESS_MIN_SIZE and COMPRESS_MAX_SIZE
+// are buffered and re-encoded at COMPRESS_QUALITY instead, and the response
+// reports the bytesSaved.
+func handleUpload(w http.ResponseWriter, r *http.Request) {
+	filename := normalizeFilename(r.URL.Query().Get("filename"))
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+	if filenameTooLong(filename) {
+		http.Error(w, fmt.Sprintf("Filename exceeds %d characters", maxFilenameLength), http.StatusBadRequest)
+		return
+	}
+	if !filenameAllowed(filename) {
+		http.Error(w, "Filename does not match the required pattern", http.StatusBadRequest)
+		return
+	}
+	if keyBlocked(filename) {
+		http.Error(w, "Filename is reserved", http.StatusForbidden)
+		return
+	}
+	filename = userFilename(r, hashedFilename(filename))
+
+	chunked := r.ContentLength < 0
+	if r.ContentLength == 0 {
+		http.Error(w, "Empty body", http.StatusBadRequest)
+		return
+	}
+	limit, limited := maxSizeFor(filename)
+	if limited && r.ContentLength > limit {
+		http.Error(w, fmt.Sprintf("Body exceeds the %d byte limit for this file type", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	contentType, err := canonicalContentType(contentType)
+	if err != nil {
+		http.Error(w, "Invalid Content-Type", http.StatusBadRequest)
+		return
+	}
+	declaredType, _, _ := mime.ParseMediaType(contentType)
+	var compress bool
+	if v := r.URL.Query().Get("compress"); v != "" {
+		if compress, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid compress", http.StatusBadRequest)
+			return
+		}
+	}
+
+	bodyLimit := r.ContentLength
+	var quotaCapped bool
+	if chunked {
+		// Without a declared length the cap can only be enforced as the
+		// body is read, aborting the upload partway through
+		bodyLimit = maxPartNumber * uploader.PartSize
+		if limited {
+			bodyLimit = limit
+		}
+		// and that includes what is left of the user's quota
+		if left, ok := quotas.remaining(r.Context()); ok && left < bodyLimit {
+			bodyLimit, quotaCapped = left, true
+		}
+	}
+	counted := &countingReader{r: http.MaxBytesReader(w, r.Body, bodyLimit)}
+	body := throttle(r.Context(), counted)
+	if chunked {
+		buffered := bufio.NewReader(body)
+		if _, err := buffered.Peek(1); err == io.EOF {
+			http.Error(w, "Empty body", http.StatusBadRequest)
+			return
+		}
+		body = buffered
+	}
+	if verifyContentType && declaredType != "application/octet-stream" {
+		sniffedType, replay, err := sniffContentType(body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if sniffedType != declaredType {
+			http.Error(w, fmt.Sprintf("Content-Type %s does not match detected type %s", declaredType, sniffedType), http.StatusUnsupportedMediaType)
+			return
+		}
+		body = replay
+	}
+
+	// compress recompresses JPEGs, the only lossy format we can re-encode
+	// without changing how the image looks beyond the quality. It is
+	// best-effort: a body that can't be decoded or doesn't shrink is stored
+	// as sent
+	size := r.ContentLength
+	var bytesSaved int64
+	if compress && declaredType == "image/jpeg" && !chunked && size >= compressMinSize && size <= compressMaxSize {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read body: %v", err), http.StatusBadRequest)
+			return
+		}
+		body = bytes.NewReader(data)
+		smaller, err := recompressJPEG(data)
+		if err != nil {
+			log.Printf("Storing %s uncompressed: %v", filename, err)
+		}
+		if smaller != nil {
+			body = bytes.NewReader(smaller)
+			bytesSaved = size - int64(len(smaller))
+			size = int64(len(smaller))
+		}
+	}
+
+	metadata, err := uploadMetadata(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	acl, err := objectACL(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tagging, err := categoryTagging(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := keyPrefix + filename
+	if uploadCollision == collisionVersion {
+		key, err = freeKey(r.Context(), key)
+		if errors.Is(err, errNoFreeKey) {
+			http.Error(w, fmt.Sprintf("Too many existing versions of %s", filename), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking for existing object: %v", err)
+			if !respondThrottled(w, err) {
+				http.Error(w, fmt.Sprintf("Failed to check for existing object: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	input := &s3.PutObjectInput{
+		Bucket:           aws.String(bucket),
+		Key:              aws.String(key),
+		Body:             body,
+		ContentType:      aws.String(contentType),
+		Metadata:         metadata,
+		ACL:              acl,
+		GrantFullControl: grants.FullControl,
+		GrantRead:        grants.Read,
+		GrantReadACP:     grants.ReadACP,
+		GrantWriteACP:    grants.WriteACP,
+		Tagging:          tagging,
+		BucketKeyEnabled: bucketKeyEnabled(),
+	}
+	// Only a replaced object can be cached by CloudFront, and If-None-Match
+	// means nothing gets replaced
+	overwritten := false
+	if uploadCollision != collisionOverwrite {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		overwritten = invalidator.overwrites(r.Context(), key)
+	}
+
+	// Chunked uploads are charged their cap, and refunded what they didn't
+	// use once their size is known
+	charged := size
+	if chunked {
+		charged = bodyLimit
+	}
+	if usage, ok := quotas.charge(r.Context(), charged); !ok {
+		respondQuotaExceeded(w, usage)
+		return
+	}
+
+	var eTag *string
+	if chunked || size > multipartThreshold {
+		// The uploader splits the body into parts and sends them concurrently,
+		// holding at most PartSize * Concurrency bytes in memory
+		resp, err := uploader.Upload(r.Context(), input)
+		if err != nil {
+			log.Printf("Error uploading object in parts: %v", err)
+			quotas.record(r.Context(), -charged)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) && quotaCapped {
+				usage, _ := quotas.hasQuota(userFrom(r.Context()))
+				respondQuotaExceeded(w, usage)
+				return
+			}
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("Body exceeds the %d byte limit", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if hasErrorCode(err, "PreconditionFailed") {
+				http.Error(w, "An object with this key already exists", http.StatusPreconditionFailed)
+				return
+			}
+			if respondThrottled(w, err) {
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to upload object: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if chunked {
+			quotas.record(r.Context(), counted.n-charged)
+		}
+		eTag = resp.ETag
+	} else {
+		input.ContentLength = aws.Int64(size)
+		resp, err := s3Client.PutObject(r.Context(), input, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+		if err != nil {
+			log.Printf("Error uploading object: %v", err)
+			quotas.record(r.Context(), -size)
+			if hasErrorCode(err, "PreconditionFailed") {
+				http.Error(w, "An object with this key already exists", http.StatusPreconditionFailed)
+				return
+			}
+			if respondThrottled(w, err) {
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to upload object: %v", err), http.StatusInternalServerError)
+			return
+		}
+		eTag = resp.ETag
+	}
+	if overwritten {
+		invalidator.invalidate(key)
+	}
+	objectHeads.invalidate(key)
+	if !chunked {
+		declaredUploadSizes.Observe(float64(r.ContentLength))
+	}
+	transferredUploadSizes.Observe(float64(counted.n))
+
+	resp := map[string]any{
+		"key":  key,
+		"eTag": aws.ToString(eTag),
+	}
+	if compress {
+		resp["bytesSaved"] = bytesSaved
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// uploadS3 answers PutObject and the multipart calls of the uploader,
+// recording the operation of each call.
+func uploadS3(t *testing.T) func() []string {
+	var mu sync.Mutex
+	var operations []string
+	fakeS3(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		query := r.URL.Query()
+		var operation string
+		switch {
+		case r.Method == http.MethodPost && query.Has("uploads"):
+			operation = "CreateMultipartUpload"
+			w.Write([]byte(`<InitiateMultipartUploadResult><Bucket>b</Bucket><Key>k</Key><UploadId>U1</UploadId></InitiateMultipartUploadResult>`))
+		case r.Method == http.MethodPut && query.Has("partNumber"):
+			operation = "UploadPart"
+			w.Header().Set("ETag", `"p"`)
+		case r.Method == http.MethodPost:
+			operation = "CompleteMultipartUpload"
+			w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"c-1"</ETag></CompleteMultipartUploadResult>`))
+		case r.Method == http.MethodPut:
+			operation = "PutObject"
+			w.Header().Set("ETag", `"e"`)
+		default:
+			operation = r.Method
+		}
+		mu.Lock()
+		operations = append(operations, operation)
+		mu.Unlock()
+	})
+	setGlobal(t, &uploader, newUploader(s3Client, 5<<20, 2))
+	setGlobal(t, &multipartThreshold, 64<<20)
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), operations...)
+	}
+}
+
+func TestUploadChunked(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		chunked    bool
+		status     int
+		operations []string
+	}{
+		{"with Content-Length", "hello world", false, http.StatusOK, []string{"PutObject"}},
+		// The uploader reads a part before choosing, so a short chunked body
+		// still ends up as one PutObject
+		{"chunked within a part", "hello world", true, http.StatusOK, []string{"PutObject"}},
+		{"chunked past a part", strings.Repeat("x", 6<<20), true, http.StatusOK, []string{"CreateMultipartUpload", "UploadPart", "UploadPart", "CompleteMultipartUpload"}},
+		{"chunked and empty", "", true, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operations := uploadS3(t)
+			req := httptest.NewRequest(http.MethodPost, "/upload?filename=a.txt", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+				req.TransferEncoding = []string{"chunked"}
+			}
+			req.Header.Set("Content-Type", "text/plain")
+			rec := httptest.NewRecorder()
+			routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body)
+			}
+			if got := operations(); strings.Join(got, ",") != strings.Join(tt.operations, ",") {
+				t.Errorf("S3 calls = %v, want %v", got, tt.operations)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// version is stamped at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// userAgentOptions tag every AWS request with "<product>/<version>" so this
+// service's calls can be picked out in CloudTrail and AWS support cases.
+func userAgentOptions(product string) []func(*middleware.Stack) error {
+	return []func(*middleware.Stack) error{
+		awsmiddleware.AddUserAgentKeyValue(product, version),
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestUserAgentOptions(t *testing.T) {
+	tests := []struct {
+		product string
+		version string
+		want    string
+	}{
+		{"generate-presigned-key", "dev", "generate-presigned-key/dev"},
+		{"uploads", "1.4.2", "uploads/1.4.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			setGlobal(t, &version, tt.version)
+			var userAgent string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userAgent = r.Header.Get("User-Agent")
+			}))
+			defer srv.Close()
+
+			client := s3.New(s3.Options{
+				Region:       "us-east-1",
+				BaseEndpoint: aws.String(srv.URL),
+				UsePathStyle: true,
+				Credentials:  credentials.NewStaticCredentialsProvider("AK", "SK", ""),
+				APIOptions:   userAgentOptions(tt.product),
+			})
+			if _, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
+				Bucket: aws.String("b"),
+				Key:    aws.String("k"),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(userAgent, tt.want) {
+				t.Errorf("User-Agent = %q, want it to contain %q", userAgent, tt.want)
+			}
+		})
+	}
+}
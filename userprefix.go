@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// userPrefixes, set by USER_PREFIXES, gives every user of USER_TOKENS a
+// space of their own: the filenames they send are taken relative to
+// keyPrefix/<user ID>/, so whatever path a client puts in a filename it can
+// only reach its own objects. Endpoints taking full keys refuse keys outside
+// it. This applies to reads as well as writes, which therefore also need a
+// user token.
+var userPrefixes bool
+
+// scopedToUser is withUser for endpoints that need no token unless
+// USER_PREFIXES is set, when they must know whose space to work in.
+func scopedToUser(next http.HandlerFunc) http.HandlerFunc {
+	if !userPrefixes {
+		return next
+	}
+	return withUser(next)
+}
+
+// userFilename places a client-supplied filename in the requesting user's
+// space.
+func userFilename(r *http.Request, filename string) string {
+	if !userPrefixes {
+		return filename
+	}
+	return userFrom(r.Context()) + "/" + filename
+}
+
+// userKeyPrefix is the prefix every key of the requesting user starts with.
+func userKeyPrefix(r *http.Request) string {
+	return keyPrefix + userFilename(r, "")
+}
+
+// validUserPrefixes checks the user IDs of USER_TOKENS can be used as a
+// single key segment.
+func validUserPrefixes(userTokens map[string]string) bool {
+	for _, user := range userTokens {
+		if user == "" || strings.Contains(user, "/") {
+			return false
+		}
+	}
+	return true
+}
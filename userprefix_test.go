@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// With USER_PREFIXES, endpoints taking full keys must refuse another user's,
+// and a request without a token.
+func TestUserPrefixIsolation(t *testing.T) {
+	own, other := keyPrefix+"alice/a.bin", keyPrefix+"bob/a.bin"
+	complete := func(key string) string {
+		return `{"key":"` + key + `","uploadId":"U1","parts":[{"eTag":"e1","partNumber":1}]}`
+	}
+	copyPart := func(key, source string) string {
+		return fmt.Sprintf(`{"key":%q,"uploadId":"U1","partNumber":1,"copySource":%q}`, key, source)
+	}
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"complete", http.MethodPost, "/multipart/complete", complete(other)},
+		{"confirm", http.MethodPost, "/confirm", `{"key":"` + other + `"}`},
+		{"copy-part destination", http.MethodPost, "/multipart/copy-part", copyPart(other, own)},
+		{"copy-part source", http.MethodPost, "/multipart/copy-part", copyPart(own, other)},
+		{"batch presign", http.MethodGet, "/multipart/presigned/batch?key=" + other + "&uploadId=U1&count=2", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			fakeS3(t, func(http.ResponseWriter, *http.Request) { calls++ })
+			setGlobal(t, &userPrefixes, true)
+			setGlobal(t, &userTokens, map[string]string{"token-a": "alice", "token-b": "bob"})
+
+			for _, token := range []string{"token-a", ""} {
+				req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+				req.Header.Set("Content-Type", "application/json")
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				rec := httptest.NewRecorder()
+				routes().ServeHTTP(rec, req)
+				if rec.Code != http.StatusBadRequest && rec.Code != http.StatusUnauthorized {
+					t.Errorf("token %q: status = %d, want 400 or 401; body %q", token, rec.Code, rec.Body)
+				}
+			}
+			if calls != 0 {
+				t.Errorf("%d S3 calls for bob's key, want none", calls)
+			}
+		})
+	}
+}
+
+// The same requests for the user's own keys get past the prefix check.
+func TestUserPrefixOwnKeys(t *testing.T) {
+	own := keyPrefix + "alice/a.bin"
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"complete", http.MethodPost, "/multipart/complete", `{"key":"` + own + `","uploadId":"U1","parts":[{"eTag":"e1","partNumber":1}]}`},
+		{"copy-part", http.MethodPost, "/multipart/copy-part", `{"key":"` + own + `","uploadId":"U1","partNumber":1,"copySource":"` + own + `"}`},
+		{"batch presign", http.MethodGet, "/multipart/presigned/batch?key=" + own + "&uploadId=U1&count=2", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copyPartS3(t)
+			setGlobal(t, &userPrefixes, true)
+			setGlobal(t, &userTokens, map[string]string{"token-a": "alice", "token-b": "bob"})
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer token-a")
+			rec := httptest.NewRecorder()
+			routes().ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200; body %q", rec.Code, rec.Body)
+			}
+		})
+	}
+}
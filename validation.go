@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErrors collects every problem with a request so the client can
+// fix them all at once instead of one per round trip.
+type validationErrors []fieldError
+
+func (v *validationErrors) add(field, message string) {
+	*v = append(*v, fieldError{Field: field, Message: message})
+}
+
+// respond writes the collected errors as a 400 and reports whether there were
+// any.
+func (v validationErrors) respond(w http.ResponseWriter) bool {
+	if len(v) == 0 {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": v,
+	})
+	return true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// callerIdentity holds the IAM identity the service runs as, looked up with
+// STS on first use. Credentials may rotate but the principal behind them
+// doesn't, so a successful answer is kept for good; failures are retried on
+// the next call.
+type callerIdentity struct {
+	client *sts.Client
+
+	mu       sync.Mutex
+	identity *identity
+}
+
+type identity struct {
+	Account string `json:"account"`
+	ARN     string `json:"arn"`
+	UserID  string `json:"userId"`
+}
+
+var whoami *callerIdentity
+
+func newCallerIdentity(client *sts.Client) *callerIdentity {
+	return &callerIdentity{client: client}
+}
+
+func (c *callerIdentity) get(ctx context.Context) (*identity, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.identity != nil {
+		return c.identity, nil
+	}
+
+	resp, err := c.client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+	c.identity = &identity{
+		Account: aws.ToString(resp.Account),
+		ARN:     aws.ToString(resp.Arn),
+		UserID:  aws.ToString(resp.UserId),
+	}
+	return c.identity, nil
+}
+
+// handleWhoami reports the account, ARN and user ID of the credentials the
+// service signs with, for telling which role it is running as when S3 denies
+// access. It is admin-only since it gives away the account ID.
+func handleWhoami(w http.ResponseWriter, r *http.Request) {
+	id, err := whoami.get(r.Context())
+	if err != nil {
+		log.Printf("Error getting caller identity: %v", err)
+		if !respondThrottled(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to get caller identity: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(id)
+}